@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"runtime"
@@ -10,9 +11,11 @@ import (
 	"github.com/spf13/cobra"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
 	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
 	"github.com/openshift/library-go/pkg/controller/controllercmd"
 
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
@@ -25,8 +28,10 @@ import (
 const metricsPort = 60000
 
 var (
-	kubeconfig   string
-	filesToWatch []string
+	kubeconfig        string
+	filesToWatch      []string
+	verifyPermissions bool
+	fips              bool
 )
 
 func printVersion() {
@@ -86,6 +91,41 @@ func main() {
 	cmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster")
 	cmd.Flags().StringArrayVar(&filesToWatch, "files", []string{}, "List of files to watch")
 
+	checkStorageCmd := &cobra.Command{
+		Use:   "check-storage",
+		Short: "Check that the image registry storage is accessible with the current credentials",
+		Run: func(cmd *cobra.Command, args []string) {
+			restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			// The FIPS mode of the host is used unless it is set
+			// explicitly, it matches the cluster only in the operator pod.
+			var fipsEnabled *bool
+			if cmd.Flags().Changed("fips") {
+				fipsEnabled = &fips
+			}
+
+			cond, err := operator.CheckStorage(ctx, restConfig, verifyPermissions, fipsEnabled)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("%s=%s %s\n", cond.Type, cond.Status, cond.Reason)
+			if cond.Message != "" {
+				fmt.Println(cond.Message)
+			}
+			if cond.Status != operatorv1.ConditionFalse {
+				os.Exit(1)
+			}
+		},
+	}
+	checkStorageCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster")
+	checkStorageCmd.Flags().BoolVar(&verifyPermissions, "verify-permissions", false, "Write a test object into the storage to verify that the credentials allow to modify it")
+	checkStorageCmd.Flags().BoolVar(&fips, "fips", false, "Whether the cluster runs in FIPS mode. Defaults to the FIPS mode of the host")
+	cmd.AddCommand(checkStorageCmd)
+
 	if err := cmd.Execute(); err != nil {
 		klog.Errorf("%v", err)
 		os.Exit(1)
@@ -226,6 +226,10 @@ func (f *FixturesBuilder) BuildListers() *client.Listers {
 			OpenShiftConfig:        corev1listers.NewConfigMapLister(f.configMapsIndexer).ConfigMaps("openshift-config"),
 			OpenShiftConfigManaged: corev1listers.NewConfigMapLister(f.configMapsIndexer).ConfigMaps("openshift-config-managed"),
 			Secrets:                corev1listers.NewSecretLister(f.secretsIndexer).Secrets("openshift-image-registry"),
+			// Unit tests should not depend on the FIPS mode of the host.
+			FIPSEnabled: func() (bool, error) {
+				return false, nil
+			},
 		},
 		Deployments:         appsv1listers.NewDeploymentLister(f.deploymentIndexer).Deployments("openshift-image-registry"),
 		Services:            corev1listers.NewServiceLister(f.servicesIndexer).Services("openshift-image-registry"),
@@ -7,6 +7,7 @@ import (
 	kcorelisters "k8s.io/client-go/listers/core/v1"
 	kpolicylisters "k8s.io/client-go/listers/policy/v1"
 	krbaclisters "k8s.io/client-go/listers/rbac/v1"
+	kstoragelisters "k8s.io/client-go/listers/storage/v1"
 
 	configlisters "github.com/openshift/client-go/config/listers/config/v1"
 	regoplisters "github.com/openshift/client-go/imageregistry/listers/imageregistry/v1"
@@ -19,6 +20,17 @@ type StorageListers struct {
 	OpenShiftConfig        kcorelisters.ConfigMapNamespaceLister
 	OpenShiftConfigManaged kcorelisters.ConfigMapNamespaceLister
 	Secrets                kcorelisters.SecretNamespaceLister
+	StorageClasses         kstoragelisters.StorageClassLister
+
+	// FIPSEnabled reports whether the cluster runs in FIPS mode. When it is
+	// nil, drivers assume that the cluster runs in the same mode as the
+	// host, which is true for the operator itself.
+	FIPSEnabled func() (bool, error)
+
+	// CheckSchedule keeps track of periodic checks that drivers make while
+	// the storage is reconciled. It is nil for callers that only probe the
+	// storage, drivers skip these checks then.
+	CheckSchedule *CheckSchedule
 }
 
 func NewStorageListers(
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// CheckSchedule tells when periodic checks of the storage are due. Drivers
+// are created on every sync, so the schedule is kept by the controller that
+// reconciles the storage and is shared with drivers through StorageListers.
+type CheckSchedule struct {
+	mtx  sync.Mutex
+	next map[string]time.Time
+}
+
+func NewCheckSchedule() *CheckSchedule {
+	return &CheckSchedule{
+		next: make(map[string]time.Time),
+	}
+}
+
+// Due returns true if the check identified by key should run now.
+func (s *CheckSchedule) Due(key string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return !time.Now().Before(s.next[key])
+}
+
+// Postpone delays the next run of the check identified by key by interval.
+func (s *CheckSchedule) Postpone(key string, interval time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.next[key] = time.Now().Add(interval)
+}
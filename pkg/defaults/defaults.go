@@ -87,12 +87,38 @@ const (
 	// medium is configured to automatically cleanup incomplete uploads
 	StorageIncompleteUploadCleanupEnabled = "StorageIncompleteUploadCleanupEnabled"
 
+	// StorageSecureTransportEnforced denotes whether or not the registry
+	// storage medium that we created only accepts HTTPS connections with
+	// TLS 1.2 or newer
+	StorageSecureTransportEnforced = "StorageSecureTransportEnforced"
+
+	// StorageFIPSEndpoint denotes whether or not the registry storage
+	// medium is accessed through a FIPS compliant endpoint when the cluster
+	// runs in FIPS mode
+	StorageFIPSEndpoint = "StorageFIPSEndpoint"
+
+	// StorageReadWriteOnce denotes whether or not the registry storage
+	// medium that we created uses the ReadWriteOnce access mode because the
+	// default storage class cannot provision ReadWriteMany volumes
+	StorageReadWriteOnce = "StorageReadWriteOnce"
+
 	// VersionAnnotation reflects the version of the registry that this deployment
 	// is running.
 	VersionAnnotation = "release.openshift.io/version"
 
 	ImageRegistryOperatorResourceFinalizer = "imageregistry.operator.openshift.io/finalizer"
 
+	// PreserveStorageAnnotation can be set to "true" on the registry config
+	// to keep the storage when the registry is removed, even if the storage
+	// is managed by the operator.
+	PreserveStorageAnnotation = "imageregistry.operator.openshift.io/preserve-storage"
+
+	// BlockPublicAccessAnnotation can be set to "false" on the registry
+	// config to create GCS buckets without uniform bucket-level access and
+	// public access prevention, and to stop reconciling these settings on
+	// the managed bucket.
+	BlockPublicAccessAnnotation = "imageregistry.operator.openshift.io/block-public-access"
+
 	ChecksumOperatorAnnotation     = "imageregistry.operator.openshift.io/checksum"
 	ChecksumOperatorDepsAnnotation = "imageregistry.operator.openshift.io/dependencies-checksum"
 
@@ -104,8 +130,7 @@ const (
 	HealthzRoute          = "/healthz"
 	HealthzTimeoutSeconds = 5
 
-	ImageConfigName   = "cluster"
-	ClusterConfigName = "cluster-config-v1"
+	ImageConfigName = "cluster"
 
 	ServiceCAName = "serviceca"
 	TrustedCAName = "trusted-ca"
@@ -33,6 +33,13 @@ var (
 		},
 		[]string{"storage"},
 	)
+	storageAccessible = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "image_registry_operator_storage_accessible",
+			Help: "Result of the last periodic storage health check. 0 = the storage is not accessible with the current credentials, 1 = accessible",
+		},
+		[]string{},
+	)
 )
 
 func init() {
@@ -42,5 +49,6 @@ func init() {
 		azurePrimaryKeyCache,
 		imageStreamTags,
 		storageType,
+		storageAccessible,
 	)
 }
@@ -81,6 +81,21 @@ func ReportStorageType(stype string) {
 	storageType.WithLabelValues(stype).Set(1)
 }
 
+// ReportStorageAccessible reports the result of the last storage health check.
+func ReportStorageAccessible(accessible bool) {
+	if accessible {
+		storageAccessible.WithLabelValues().Set(1)
+		return
+	}
+	storageAccessible.WithLabelValues().Set(0)
+}
+
+// ResetStorageAccessible removes the result of the storage health check,
+// e.g. when the storage is removed and there is nothing to check.
+func ResetStorageAccessible() {
+	storageAccessible.Reset()
+}
+
 // AzureKeyCacheHit registers a hit on Azure key cache.
 func AzureKeyCacheHit() {
 	azurePrimaryKeyCache.With(map[string]string{"result": "hit"}).Inc()
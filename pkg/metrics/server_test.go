@@ -201,6 +201,60 @@ func TestImagePrunerInstallStatus(t *testing.T) {
 	}
 }
 
+func TestStorageAccessible(t *testing.T) {
+	metricName := "image_registry_operator_storage_accessible"
+	for _, tt := range []struct {
+		name       string
+		accessible bool
+		expt       float64
+	}{
+		{
+			name:       "not accessible",
+			accessible: false,
+			expt:       0,
+		},
+		{
+			name:       "accessible",
+			accessible: true,
+			expt:       1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ReportStorageAccessible(tt.accessible)
+
+			resp, err := http.Get("https://localhost:5000/metrics")
+			if err != nil {
+				t.Fatalf("error requesting metrics server: %v", err)
+			}
+
+			metrics := findMetricsByCounter(resp.Body, metricName)
+			if len(metrics) == 0 {
+				t.Fatal("unable to locate metric", metricName)
+			}
+
+			if val := metrics[0].Gauge.GetValue(); val != tt.expt {
+				t.Errorf("expected %.0f, found %.0f", tt.expt, val)
+			}
+		})
+	}
+}
+
+func TestResetStorageAccessible(t *testing.T) {
+	metricName := "image_registry_operator_storage_accessible"
+
+	ReportStorageAccessible(true)
+	ResetStorageAccessible()
+
+	resp, err := http.Get("https://localhost:5000/metrics")
+	if err != nil {
+		t.Fatalf("error requesting metrics server: %v", err)
+	}
+
+	if metrics := findMetricsByCounter(resp.Body, metricName); len(metrics) != 0 {
+		t.Errorf("expected metric %s to be removed, found %v", metricName, metrics)
+	}
+}
+
 func findMetricsByCounter(buf io.ReadCloser, name string) []*io_prometheus_client.Metric {
 	defer buf.Close()
 	mf := io_prometheus_client.MetricFamily{}
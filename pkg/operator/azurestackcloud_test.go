@@ -0,0 +1,66 @@
+package operator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+)
+
+func TestAzureStackCloudSyncConfig(t *testing.T) {
+	const endpoints = `{"name":"AzureStackCloud","resourceManagerEndpoint":"https://management.local.azurestack.external/"}`
+
+	filename := filepath.Join(t.TempDir(), "azurestackcloud.json")
+	t.Setenv("AZURE_ENVIRONMENT_FILEPATH", filename)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	c := &AzureStackCloudController{
+		openshiftConfigLister: corev1listers.NewConfigMapLister(indexer).ConfigMaps(defaults.OpenShiftConfigNamespace),
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cloud-provider-config",
+			Namespace: defaults.OpenShiftConfigNamespace,
+		},
+		Data: map[string]string{
+			"endpoints": endpoints,
+		},
+	}
+	if err := indexer.Add(cm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.syncConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unable to read the environment file: %v", err)
+	}
+	if string(data) != endpoints {
+		t.Errorf("expected the environment file to contain %q, got %q", endpoints, data)
+	}
+
+	if err := indexer.Delete(cm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.syncConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("expected the environment file to be removed, got %v", err)
+	}
+
+	// Nothing to remove, the sync should still succeed.
+	if err := c.syncConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package operator
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -36,10 +37,10 @@ import (
 	"github.com/openshift/cluster-image-registry-operator/pkg/resource/object"
 	"github.com/openshift/cluster-image-registry-operator/pkg/resource/strategy"
 	"github.com/openshift/cluster-image-registry-operator/pkg/storage"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 )
 
 const (
-	kubeSystemNamespace   = "kube-system"
 	workqueueKey          = "changes"
 	defaultResyncDuration = 10 * time.Minute
 )
@@ -74,12 +75,12 @@ func NewController(
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
 	openshiftConfigKubeInformerFactory kubeinformers.SharedInformerFactory,
 	openshiftConfigManagedKubeInformerFactory kubeinformers.SharedInformerFactory,
-	kubeSystemKubeInformerFactory kubeinformers.SharedInformerFactory,
 	configInformerFactory configinformers.SharedInformerFactory,
 	regopInformerFactory imageregistryinformers.SharedInformerFactory,
 	routeInformerFactory routeinformers.SharedInformerFactory,
 ) (*Controller, error) {
 	listers := &regopclient.Listers{}
+	listers.CheckSchedule = regopclient.NewCheckSchedule()
 	clients := &regopclient.Clients{}
 	c := &Controller{
 		kubeconfig: kubeconfig,
@@ -172,6 +173,11 @@ func NewController(
 			c.listers.Infrastructures = informer.Lister()
 			return informer.Informer()
 		},
+		func() cache.SharedIndexInformer {
+			informer := kubeInformerFactory.Storage().V1().StorageClasses()
+			c.listers.StorageClasses = informer.Lister()
+			return informer.Informer()
+		},
 	} {
 		informer := ctor()
 		if _, err := informer.AddEventHandler(c.handler()); err != nil {
@@ -206,9 +212,18 @@ func (c *Controller) createOrUpdateResources(cr *imageregistryv1.Config) error {
 		return err
 	}
 
+	var invalidConfigErr *util.InvalidConfigurationError
+	var permErr *util.InsufficientPermissionsError
+	var quotaErr *util.QuotaExceededError
 	err = c.generator.Apply(cr)
 	if err == storage.ErrStorageNotConfigured {
 		return newPermanentError("StorageNotConfigured", err)
+	} else if goerrors.As(err, &invalidConfigErr) {
+		return newPermanentError("InvalidStorageConfiguration", err)
+	} else if goerrors.As(err, &permErr) {
+		return newPermanentError(util.InsufficientPermissionsReason, err)
+	} else if goerrors.As(err, &quotaErr) {
+		return newPermanentError(util.StorageQuotaExceededReason, err)
 	} else if err != nil {
 		return err
 	}
@@ -386,10 +401,6 @@ func (c *Controller) eventProcessor() {
 func (c *Controller) handler() cache.ResourceEventHandlerFuncs {
 	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(o interface{}) {
-			obj := o.(metaapi.Object)
-			if obj.GetNamespace() == kubeSystemNamespace && obj.GetName() != defaults.ClusterConfigName {
-				return
-			}
 			klog.V(4).Infof("add event to workqueue due to %s (add)", utilObjectInfo(o))
 			c.workqueue.Add(workqueueKey)
 		},
@@ -409,10 +420,6 @@ func (c *Controller) handler() cache.ResourceEventHandlerFuncs {
 				// Two different versions of the same resource will always have different RVs.
 				return
 			}
-			obj := o.(metaapi.Object)
-			if obj.GetNamespace() == kubeSystemNamespace && obj.GetName() != defaults.ClusterConfigName {
-				return
-			}
 			klog.V(4).Infof("add event to workqueue due to %s (update)", utilObjectInfo(n))
 			c.workqueue.Add(workqueueKey)
 		},
@@ -431,9 +438,6 @@ func (c *Controller) handler() cache.ResourceEventHandlerFuncs {
 				}
 				klog.V(4).Infof("recovered deleted object %q from tombstone", object.GetName())
 			}
-			if object.GetNamespace() == kubeSystemNamespace && object.GetName() != defaults.ClusterConfigName {
-				return
-			}
 			klog.V(4).Infof("add event to workqueue due to %s (delete)", utilObjectInfo(object))
 			c.workqueue.Add(workqueueKey)
 		},
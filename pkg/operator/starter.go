@@ -49,7 +49,6 @@ func RunOperator(ctx context.Context, kubeconfig *restclient.Config) error {
 	kubeInformers := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, defaultResyncDuration, kubeinformers.WithNamespace(defaults.ImageRegistryOperatorNamespace))
 	kubeInformersForOpenShiftConfig := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, defaultResyncDuration, kubeinformers.WithNamespace(defaults.OpenShiftConfigNamespace))
 	kubeInformersForOpenShiftConfigManaged := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, defaultResyncDuration, kubeinformers.WithNamespace(defaults.OpenShiftConfigManagedNamespace))
-	kubeInformersForKubeSystem := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, defaultResyncDuration, kubeinformers.WithNamespace(kubeSystemNamespace))
 	configInformers := configinformers.NewSharedInformerFactory(configClient, defaultResyncDuration)
 	imageregistryInformers := imageregistryinformers.NewSharedInformerFactory(imageregistryClient, defaultResyncDuration)
 	routeInformers := routeinformers.NewSharedInformerFactoryWithOptions(routeClient, defaultResyncDuration, routeinformers.WithNamespace(defaults.ImageRegistryOperatorNamespace))
@@ -78,7 +77,6 @@ func RunOperator(ctx context.Context, kubeconfig *restclient.Config) error {
 		kubeInformers,
 		kubeInformersForOpenShiftConfig,
 		kubeInformersForOpenShiftConfigManaged,
-		kubeInformersForKubeSystem,
 		configInformers,
 		imageregistryInformers,
 		routeInformers,
@@ -168,12 +166,22 @@ func RunOperator(ctx context.Context, kubeconfig *restclient.Config) error {
 		return err
 	}
 
+	storageHealthController := NewStorageHealthController(
+		kubeconfig,
+		configOperatorClient,
+		imageregistryInformers.Imageregistry().V1().Configs(),
+		configInformers.Config().V1().Infrastructures(),
+		kubeInformersForOpenShiftConfig.Core().V1().ConfigMaps(),
+		kubeInformersForOpenShiftConfigManaged.Core().V1().ConfigMaps(),
+		kubeInformers.Core().V1().Secrets(),
+		kubeInformers.Storage().V1().StorageClasses(),
+	)
+
 	metricsController := NewMetricsController(imageInformers.Image().V1().ImageStreams())
 
 	kubeInformers.Start(ctx.Done())
 	kubeInformersForOpenShiftConfig.Start(ctx.Done())
 	kubeInformersForOpenShiftConfigManaged.Start(ctx.Done())
-	kubeInformersForKubeSystem.Start(ctx.Done())
 	configInformers.Start(ctx.Done())
 	imageregistryInformers.Start(ctx.Done())
 	routeInformers.Start(ctx.Done())
@@ -187,6 +195,7 @@ func RunOperator(ctx context.Context, kubeconfig *restclient.Config) error {
 	go imagePrunerController.Run(ctx.Done())
 	go loggingController.Run(ctx, 1)
 	go azureStackCloudController.Run(ctx)
+	go storageHealthController.Run(ctx)
 	go metricsController.Run(ctx)
 
 	<-ctx.Done()
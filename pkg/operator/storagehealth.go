@@ -0,0 +1,355 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	kubeclient "k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	configv1informers "github.com/openshift/client-go/config/informers/externalversions/config/v1"
+	imageregistryclient "github.com/openshift/client-go/imageregistry/clientset/versioned"
+	imageregistryv1informers "github.com/openshift/client-go/imageregistry/informers/externalversions/imageregistry/v1"
+	imageregistryv1listers "github.com/openshift/client-go/imageregistry/listers/imageregistry/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+
+	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
+	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+	"github.com/openshift/cluster-image-registry-operator/pkg/metrics"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
+)
+
+const (
+	// storageHealthCheckInterval is how often the storage backend is probed.
+	storageHealthCheckInterval = 5 * time.Minute
+
+	// storageCheckFailureThreshold is the number of consecutive probes that
+	// should fail with an unexpected error before the failure is reported.
+	storageCheckFailureThreshold = 3
+
+	storageDegradedConditionType = "StorageDegraded"
+	storageCheckFailedReason     = "StorageCheckFailed"
+	invalidConfigurationReason   = "InvalidStorageConfiguration"
+)
+
+// errStorageNotProvisioned is returned by CheckStorage when the operator has
+// not set up the storage yet.
+var errStorageNotProvisioned = errors.New("the image registry storage is not provisioned yet")
+
+// StorageHealthController periodically verifies that the configured storage
+// backend is still reachable with the current credentials and reports the
+// result as the StorageDegraded condition and the
+// image_registry_operator_storage_accessible metric. It allows to detect
+// broken credentials or removed buckets before users hit failed pushes.
+type StorageHealthController struct {
+	kubeconfig     *restclient.Config
+	operatorClient v1helpers.OperatorClient
+	configLister   imageregistryv1listers.ConfigLister
+	storageListers *regopclient.StorageListers
+
+	// newDriver is storage.NewDriver, it is replaced during tests.
+	newDriver func(*imageregistryv1.ImageRegistryConfigStorage, *restclient.Config, *regopclient.StorageListers) (storage.Driver, error)
+
+	// consecutiveFailures is the number of probes in a row that failed
+	// with an unexpected error.
+	consecutiveFailures int
+
+	cachesToSync []cache.InformerSynced
+}
+
+func NewStorageHealthController(
+	kubeconfig *restclient.Config,
+	operatorClient v1helpers.OperatorClient,
+	imageRegistryConfigInformer imageregistryv1informers.ConfigInformer,
+	infrastructureInformer configv1informers.InfrastructureInformer,
+	openshiftConfigInformer corev1informers.ConfigMapInformer,
+	openshiftConfigManagedInformer corev1informers.ConfigMapInformer,
+	secretInformer corev1informers.SecretInformer,
+	storageClassInformer storagev1informers.StorageClassInformer,
+) *StorageHealthController {
+	storageListers := regopclient.NewStorageListers(
+		infrastructureInformer.Lister(),
+		openshiftConfigInformer.Lister().ConfigMaps(defaults.OpenShiftConfigNamespace),
+		openshiftConfigManagedInformer.Lister().ConfigMaps(defaults.OpenShiftConfigManagedNamespace),
+		secretInformer.Lister().Secrets(defaults.ImageRegistryOperatorNamespace),
+	)
+	storageListers.StorageClasses = storageClassInformer.Lister()
+
+	return &StorageHealthController{
+		kubeconfig:     kubeconfig,
+		operatorClient: operatorClient,
+		configLister:   imageRegistryConfigInformer.Lister(),
+		storageListers: storageListers,
+		newDriver:      storage.NewDriver,
+		cachesToSync: []cache.InformerSynced{
+			imageRegistryConfigInformer.Informer().HasSynced,
+			infrastructureInformer.Informer().HasSynced,
+			openshiftConfigInformer.Informer().HasSynced,
+			openshiftConfigManagedInformer.Informer().HasSynced,
+			secretInformer.Informer().HasSynced,
+			storageClassInformer.Informer().HasSynced,
+		},
+	}
+}
+
+// storageHealthCondition probes the storage backend using drv and returns the
+// StorageDegraded condition that reflects the result. Drivers that support it
+// also check that the storage quota is not exhausted. If verifyPermissions is
+// set and the driver supports it, the probe also verifies that the
+// credentials allow to modify the storage. This check writes into the
+// storage.
+func storageHealthCondition(drv storage.Driver, cr *imageregistryv1.Config, verifyPermissions bool) operatorv1.OperatorCondition {
+	cond := operatorv1.OperatorCondition{
+		Type:   storageDegradedConditionType,
+		Status: operatorv1.ConditionFalse,
+		Reason: "AsExpected",
+	}
+
+	// The storage has not been provisioned yet, there is nothing to probe.
+	if drv.ID() == "" {
+		return cond
+	}
+
+	// StorageExists updates conditions on the object it gets, we don't want
+	// to change the cached version.
+	cr = cr.DeepCopy()
+	exists, err := drv.StorageExists(cr)
+	existsCond := util.FetchCondition(cr, defaults.StorageExists)
+	var configErr *util.InvalidConfigurationError
+	switch {
+	case errors.As(err, &configErr):
+		return invalidConfigurationCondition(err)
+	case err != nil && existsCond.Status != operatorv1.ConditionFalse:
+		cond.Status = operatorv1.ConditionTrue
+		cond.Reason = storageCheckFailedReason
+		cond.Message = fmt.Sprintf("Unable to check the storage backend: %s", err)
+	case err != nil || !exists:
+		// Drivers may return an error and still report why the storage is
+		// not accessible, e.g. when the credentials are rejected.
+		cond.Status = operatorv1.ConditionTrue
+		cond.Reason = "StorageNotAccessible"
+		cond.Message = fmt.Sprintf("The storage backend %s is not accessible: %s", drv.ID(), existsCond.Reason)
+		if existsCond.Message != "" {
+			cond.Message = fmt.Sprintf("%s: %s", cond.Message, existsCond.Message)
+		}
+	default:
+		var err error
+		if checker, ok := drv.(storage.QuotaChecker); ok {
+			err = checker.CheckQuota()
+		}
+		if verifier, ok := drv.(storage.PermissionsVerifier); ok && verifyPermissions && err == nil {
+			err = verifier.VerifyPermissions()
+		}
+		var permErr *util.InsufficientPermissionsError
+		var quotaErr *util.QuotaExceededError
+		if errors.As(err, &permErr) {
+			cond.Status = operatorv1.ConditionTrue
+			cond.Reason = util.InsufficientPermissionsReason
+			cond.Message = fmt.Sprintf("The storage credentials are not sufficient: %s", err)
+		} else if errors.As(err, &quotaErr) {
+			cond.Status = operatorv1.ConditionTrue
+			cond.Reason = util.StorageQuotaExceededReason
+			cond.Message = fmt.Sprintf("The storage backend %s does not accept new data: %s", drv.ID(), err)
+		} else if err != nil {
+			cond.Status = operatorv1.ConditionTrue
+			cond.Reason = storageCheckFailedReason
+			cond.Message = fmt.Sprintf("Unable to check the storage backend: %s", err)
+		}
+	}
+	return cond
+}
+
+// invalidConfigurationCondition returns the StorageDegraded condition for a
+// storage configuration that cannot be used. Probing again does not help
+// until the configuration is changed.
+func invalidConfigurationCondition(err error) operatorv1.OperatorCondition {
+	return operatorv1.OperatorCondition{
+		Type:    storageDegradedConditionType,
+		Status:  operatorv1.ConditionTrue,
+		Reason:  invalidConfigurationReason,
+		Message: fmt.Sprintf("The storage configuration is not valid: %s", err),
+	}
+}
+
+// CheckStorage probes the storage backend of the image registry on the
+// cluster that kubeconfig points to and returns the StorageDegraded
+// condition. It runs the same checks as StorageHealthController, but only
+// once and without updating the operator status, so that storage
+// credentials can be diagnosed from the command line. If verifyPermissions
+// is set, the probe also writes into the storage to verify that the
+// credentials allow to modify it. fipsEnabled tells whether the cluster runs
+// in FIPS mode. If it is nil, the FIPS mode of the host is used, which matches
+// the cluster when the command runs in the operator pod.
+func CheckStorage(ctx context.Context, kubeconfig *restclient.Config, verifyPermissions bool, fipsEnabled *bool) (operatorv1.OperatorCondition, error) {
+	kubeClient, err := kubeclient.NewForConfig(kubeconfig)
+	if err != nil {
+		return operatorv1.OperatorCondition{}, err
+	}
+	configClient, err := configclient.NewForConfig(kubeconfig)
+	if err != nil {
+		return operatorv1.OperatorCondition{}, err
+	}
+	imageregistryClient, err := imageregistryclient.NewForConfig(kubeconfig)
+	if err != nil {
+		return operatorv1.OperatorCondition{}, err
+	}
+	return checkStorage(ctx, kubeconfig, kubeClient, configClient, imageregistryClient, storage.NewDriver, verifyPermissions, fipsEnabled)
+}
+
+func checkStorage(
+	ctx context.Context,
+	kubeconfig *restclient.Config,
+	kubeClient kubeclient.Interface,
+	configClient configclient.Interface,
+	imageregistryClient imageregistryclient.Interface,
+	newDriver func(*imageregistryv1.ImageRegistryConfigStorage, *restclient.Config, *regopclient.StorageListers) (storage.Driver, error),
+	verifyPermissions bool,
+	fipsEnabled *bool,
+) (operatorv1.OperatorCondition, error) {
+	kubeInformers := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0, kubeinformers.WithNamespace(defaults.ImageRegistryOperatorNamespace))
+	kubeInformersForOpenShiftConfig := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0, kubeinformers.WithNamespace(defaults.OpenShiftConfigNamespace))
+	kubeInformersForOpenShiftConfigManaged := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0, kubeinformers.WithNamespace(defaults.OpenShiftConfigManagedNamespace))
+	configInformers := configinformers.NewSharedInformerFactory(configClient, 0)
+
+	listers := regopclient.NewStorageListers(
+		configInformers.Config().V1().Infrastructures().Lister(),
+		kubeInformersForOpenShiftConfig.Core().V1().ConfigMaps().Lister().ConfigMaps(defaults.OpenShiftConfigNamespace),
+		kubeInformersForOpenShiftConfigManaged.Core().V1().ConfigMaps().Lister().ConfigMaps(defaults.OpenShiftConfigManagedNamespace),
+		kubeInformers.Core().V1().Secrets().Lister().Secrets(defaults.ImageRegistryOperatorNamespace),
+	)
+	listers.StorageClasses = kubeInformers.Storage().V1().StorageClasses().Lister()
+	if fipsEnabled != nil {
+		fips := *fipsEnabled
+		listers.FIPSEnabled = func() (bool, error) {
+			return fips, nil
+		}
+	}
+
+	kubeInformers.Start(ctx.Done())
+	kubeInformersForOpenShiftConfig.Start(ctx.Done())
+	kubeInformersForOpenShiftConfigManaged.Start(ctx.Done())
+	configInformers.Start(ctx.Done())
+
+	for _, synced := range []map[reflect.Type]bool{
+		kubeInformers.WaitForCacheSync(ctx.Done()),
+		kubeInformersForOpenShiftConfig.WaitForCacheSync(ctx.Done()),
+		kubeInformersForOpenShiftConfigManaged.WaitForCacheSync(ctx.Done()),
+		configInformers.WaitForCacheSync(ctx.Done()),
+	} {
+		for typ, ok := range synced {
+			if !ok {
+				return operatorv1.OperatorCondition{}, fmt.Errorf("unable to sync the cache for %s", typ)
+			}
+		}
+	}
+
+	cr, err := imageregistryClient.ImageregistryV1().Configs().Get(ctx, defaults.ImageRegistryResourceName, metav1.GetOptions{})
+	if err != nil {
+		return operatorv1.OperatorCondition{}, err
+	}
+
+	drv, err := newDriver(&cr.Spec.Storage, kubeconfig, listers)
+	var configErr *util.InvalidConfigurationError
+	if errors.As(err, &configErr) {
+		return invalidConfigurationCondition(err), nil
+	} else if err != nil {
+		return operatorv1.OperatorCondition{}, err
+	}
+	if drv.ID() == "" {
+		return operatorv1.OperatorCondition{}, errStorageNotProvisioned
+	}
+
+	return storageHealthCondition(drv, cr, verifyPermissions), nil
+}
+
+func (c *StorageHealthController) sync() error {
+	cr, err := c.configLister.Get(defaults.ImageRegistryResourceName)
+	if kerrors.IsNotFound(err) {
+		c.consecutiveFailures = 0
+		metrics.ResetStorageAccessible()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	cr = cr.DeepCopy() // drivers update their config, we don't want to change the cached version
+
+	cond := operatorv1.OperatorCondition{
+		Type:   storageDegradedConditionType,
+		Status: operatorv1.ConditionFalse,
+		Reason: "AsExpected",
+	}
+	probed := false
+	if cr.Spec.ManagementState == operatorv1.Managed && cr.DeletionTimestamp == nil {
+		drv, err := c.newDriver(&cr.Spec.Storage, c.kubeconfig, c.storageListers)
+		if err != nil && err != storage.ErrStorageNotConfigured {
+			return err
+		}
+		if err == nil && drv.ID() != "" {
+			// The permissions are verified when the storage is set up,
+			// the periodic probe does not write into the storage.
+			cond = storageHealthCondition(drv, cr, false)
+			probed = true
+		}
+	}
+
+	// A single probe may fail because of a timeout or a DNS issue. As this
+	// condition makes the cluster operator degraded, unexpected errors are
+	// reported only when they persist, the previous status is kept until
+	// then.
+	if cond.Reason == storageCheckFailedReason {
+		c.consecutiveFailures++
+		if c.consecutiveFailures < storageCheckFailureThreshold {
+			klog.Warningf("StorageHealthController: storage check failed (%d/%d): %s", c.consecutiveFailures, storageCheckFailureThreshold, cond.Message)
+			return nil
+		}
+	} else {
+		c.consecutiveFailures = 0
+	}
+
+	if probed {
+		metrics.ReportStorageAccessible(cond.Status == operatorv1.ConditionFalse)
+	} else {
+		metrics.ResetStorageAccessible()
+	}
+
+	_, _, err = v1helpers.UpdateStatus(context.TODO(), c.operatorClient, v1helpers.UpdateConditionFn(cond))
+	return err
+}
+
+func (c *StorageHealthController) check(_ context.Context) {
+	if err := c.sync(); err != nil {
+		klog.Errorf("StorageHealthController: unable to check storage health: %s", err)
+	}
+}
+
+func (c *StorageHealthController) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+
+	klog.Infof("Starting StorageHealthController")
+	if !cache.WaitForCacheSync(ctx.Done(), c.cachesToSync...) {
+		return
+	}
+
+	go wait.UntilWithContext(ctx, c.check, storageHealthCheckInterval)
+
+	klog.Infof("Started StorageHealthController")
+	<-ctx.Done()
+	klog.Infof("Shutting down StorageHealthController")
+}
@@ -0,0 +1,587 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefakeclient "k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	configfakeclient "github.com/openshift/client-go/config/clientset/versioned/fake"
+	imageregistryfakeclient "github.com/openshift/client-go/imageregistry/clientset/versioned/fake"
+	imageregistryv1listers "github.com/openshift/client-go/imageregistry/listers/imageregistry/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+
+	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
+	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+	"github.com/openshift/cluster-image-registry-operator/pkg/envvar"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
+)
+
+type fakeDriver struct {
+	id     string
+	exists bool
+	err    error
+	reason string
+	msg    string
+}
+
+func (d *fakeDriver) CABundle() (string, bool, error) { return "", true, nil }
+func (d *fakeDriver) ConfigEnv() (envvar.List, error) { return nil, nil }
+func (d *fakeDriver) Volumes() ([]corev1.Volume, []corev1.VolumeMount, error) {
+	return nil, nil, nil
+}
+func (d *fakeDriver) VolumeSecrets() (map[string]string, error)           { return nil, nil }
+func (d *fakeDriver) CreateStorage(*imageregistryv1.Config) error         { return nil }
+func (d *fakeDriver) RemoveStorage(*imageregistryv1.Config) (bool, error) { return false, nil }
+func (d *fakeDriver) StorageChanged(*imageregistryv1.Config) bool         { return false }
+func (d *fakeDriver) ID() string                                          { return d.id }
+func (d *fakeDriver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
+	status := operatorv1.ConditionTrue
+	if d.err != nil && d.reason == "" {
+		status = operatorv1.ConditionUnknown
+	} else if !d.exists {
+		status = operatorv1.ConditionFalse
+	}
+	util.UpdateCondition(cr, defaults.StorageExists, status, d.reason, d.msg)
+	return d.exists, d.err
+}
+
+type fakeVerifyingDriver struct {
+	fakeDriver
+	verifyErr error
+}
+
+func (d *fakeVerifyingDriver) VerifyPermissions() error {
+	return d.verifyErr
+}
+
+type fakeQuotaDriver struct {
+	fakeVerifyingDriver
+	quotaErr error
+}
+
+func (d *fakeQuotaDriver) CheckQuota() error {
+	return d.quotaErr
+}
+
+func TestStorageHealthCondition(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		driver            storage.Driver
+		verifyPermissions bool
+		expected          operatorv1.OperatorCondition
+	}{
+		{
+			name:   "storage not provisioned yet",
+			driver: &fakeDriver{},
+			expected: operatorv1.OperatorCondition{
+				Status: operatorv1.ConditionFalse,
+				Reason: "AsExpected",
+			},
+		},
+		{
+			name: "storage is accessible",
+			driver: &fakeDriver{
+				id:     "a-bucket",
+				exists: true,
+			},
+			expected: operatorv1.OperatorCondition{
+				Status: operatorv1.ConditionFalse,
+				Reason: "AsExpected",
+			},
+		},
+		{
+			name: "storage is not accessible",
+			driver: &fakeDriver{
+				id:     "a-bucket",
+				reason: "Forbidden",
+				msg:    "Forbidden: Forbidden\n\tstatus code: 403",
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "StorageNotAccessible",
+				Message: "The storage backend a-bucket is not accessible: Forbidden: Forbidden: Forbidden\n\tstatus code: 403",
+			},
+		},
+		{
+			name: "storage check failed",
+			driver: &fakeDriver{
+				id:  "a-bucket",
+				err: fmt.Errorf("connection refused"),
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "StorageCheckFailed",
+				Message: "Unable to check the storage backend: connection refused",
+			},
+		},
+		{
+			name: "storage credentials are rejected",
+			driver: &fakeDriver{
+				id:     "account/container",
+				err:    fmt.Errorf("unable to get the storage container container: AuthenticationFailed"),
+				reason: "AuthenticationFailed",
+				msg:    "unable to get the storage container container: AuthenticationFailed",
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "StorageNotAccessible",
+				Message: "The storage backend account/container is not accessible: AuthenticationFailed: unable to get the storage container container: AuthenticationFailed",
+			},
+		},
+		{
+			name: "storage configuration is not valid",
+			driver: &fakeDriver{
+				id:  "a-bucket",
+				err: &util.InvalidConfigurationError{Err: fmt.Errorf("the S3 endpoint does not use https")},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "InvalidStorageConfiguration",
+				Message: "The storage configuration is not valid: the S3 endpoint does not use https",
+			},
+		},
+		{
+			name:              "credentials are not sufficient",
+			verifyPermissions: true,
+			driver: &fakeVerifyingDriver{
+				fakeDriver: fakeDriver{
+					id:     "a-bucket",
+					exists: true,
+				},
+				verifyErr: &util.InsufficientPermissionsError{
+					Action: "s3:PutObject",
+					Err:    fmt.Errorf("access denied"),
+				},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "InsufficientPermissions",
+				Message: "The storage credentials are not sufficient: missing s3:PutObject: access denied",
+			},
+		},
+		{
+			name:              "storage quota is exhausted",
+			verifyPermissions: true,
+			driver: &fakeVerifyingDriver{
+				fakeDriver: fakeDriver{
+					id:     "registry",
+					exists: true,
+				},
+				verifyErr: &util.QuotaExceededError{
+					Err: fmt.Errorf("Upload exceeds quota."),
+				},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "StorageQuotaExceeded",
+				Message: "The storage backend registry does not accept new data: storage quota exceeded: Upload exceeds quota.",
+			},
+		},
+		{
+			name: "storage quota is exhausted after the storage is set up",
+			driver: &fakeQuotaDriver{
+				fakeVerifyingDriver: fakeVerifyingDriver{
+					fakeDriver: fakeDriver{
+						id:     "registry",
+						exists: true,
+					},
+				},
+				quotaErr: &util.QuotaExceededError{
+					Err: fmt.Errorf("the container registry holds 100 bytes, its quota is 100"),
+				},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "StorageQuotaExceeded",
+				Message: "The storage backend registry does not accept new data: storage quota exceeded: the container registry holds 100 bytes, its quota is 100",
+			},
+		},
+		{
+			name:              "permissions are verified when the quota is not exhausted",
+			verifyPermissions: true,
+			driver: &fakeQuotaDriver{
+				fakeVerifyingDriver: fakeVerifyingDriver{
+					fakeDriver: fakeDriver{
+						id:     "registry",
+						exists: true,
+					},
+					verifyErr: &util.InsufficientPermissionsError{
+						Action: "write access to the container",
+						Err:    fmt.Errorf("Forbidden"),
+					},
+				},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "InsufficientPermissions",
+				Message: "The storage credentials are not sufficient: missing write access to the container: Forbidden",
+			},
+		},
+		{
+			name:              "credentials verification failed",
+			verifyPermissions: true,
+			driver: &fakeVerifyingDriver{
+				fakeDriver: fakeDriver{
+					id:     "a-bucket",
+					exists: true,
+				},
+				verifyErr: fmt.Errorf("connection reset"),
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "StorageCheckFailed",
+				Message: "Unable to check the storage backend: connection reset",
+			},
+		},
+		{
+			name:              "credentials are sufficient",
+			verifyPermissions: true,
+			driver: &fakeVerifyingDriver{
+				fakeDriver: fakeDriver{
+					id:     "a-bucket",
+					exists: true,
+				},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status: operatorv1.ConditionFalse,
+				Reason: "AsExpected",
+			},
+		},
+		{
+			name: "permissions are not verified",
+			driver: &fakeVerifyingDriver{
+				fakeDriver: fakeDriver{
+					id:     "a-bucket",
+					exists: true,
+				},
+				verifyErr: &util.InsufficientPermissionsError{
+					Action: "s3:PutObject",
+					Err:    fmt.Errorf("access denied"),
+				},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status: operatorv1.ConditionFalse,
+				Reason: "AsExpected",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &imageregistryv1.Config{}
+			cond := storageHealthCondition(tt.driver, cr, tt.verifyPermissions)
+
+			tt.expected.Type = storageDegradedConditionType
+			validateCondition(t, tt.expected, cond)
+
+			if len(cr.Status.Conditions) != 0 {
+				t.Errorf("expected the provided config to be left untouched, got conditions %#+v", cr.Status.Conditions)
+			}
+		})
+	}
+}
+
+func newTestConfig(managementState operatorv1.ManagementState) *imageregistryv1.Config {
+	return &imageregistryv1.Config{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: defaults.ImageRegistryResourceName,
+		},
+		Spec: imageregistryv1.ImageRegistrySpec{
+			OperatorSpec: operatorv1.OperatorSpec{
+				ManagementState: managementState,
+			},
+		},
+	}
+}
+
+func TestStorageHealthControllerSync(t *testing.T) {
+	healthy := &fakeDriver{id: "a-bucket", exists: true}
+	failing := &fakeDriver{id: "a-bucket", err: fmt.Errorf("dial tcp: i/o timeout")}
+	forbidden := &fakeDriver{id: "a-bucket", reason: "Forbidden", msg: "Forbidden"}
+
+	asExpected := operatorv1.OperatorCondition{
+		Status: operatorv1.ConditionFalse,
+		Reason: "AsExpected",
+	}
+	checkFailed := operatorv1.OperatorCondition{
+		Status:  operatorv1.ConditionTrue,
+		Reason:  "StorageCheckFailed",
+		Message: "Unable to check the storage backend: dial tcp: i/o timeout",
+	}
+	notAccessible := operatorv1.OperatorCondition{
+		Status:  operatorv1.ConditionTrue,
+		Reason:  "StorageNotAccessible",
+		Message: "The storage backend a-bucket is not accessible: Forbidden: Forbidden",
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	operatorClient := v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+
+	var drv storage.Driver
+	var drvErr error
+	c := &StorageHealthController{
+		operatorClient: operatorClient,
+		configLister:   imageregistryv1listers.NewConfigLister(indexer),
+		newDriver: func(*imageregistryv1.ImageRegistryConfigStorage, *restclient.Config, *regopclient.StorageListers) (storage.Driver, error) {
+			return drv, drvErr
+		},
+	}
+
+	for _, step := range []struct {
+		name     string
+		cr       *imageregistryv1.Config
+		driver   storage.Driver
+		err      error
+		expected operatorv1.OperatorCondition
+	}{
+		{
+			name:     "storage is accessible",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   healthy,
+			expected: asExpected,
+		},
+		{
+			name:     "first failed check keeps the previous status",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   failing,
+			expected: asExpected,
+		},
+		{
+			name:     "second failed check keeps the previous status",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   failing,
+			expected: asExpected,
+		},
+		{
+			name:     "persistent failure is reported",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   failing,
+			expected: checkFailed,
+		},
+		{
+			name:     "storage recovers",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   healthy,
+			expected: asExpected,
+		},
+		{
+			name:     "failed check after recovery keeps the previous status",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   failing,
+			expected: asExpected,
+		},
+		{
+			name:     "inaccessible storage is reported immediately",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   forbidden,
+			expected: notAccessible,
+		},
+		{
+			name:     "registry is removed",
+			cr:       newTestConfig(operatorv1.Removed),
+			driver:   forbidden,
+			expected: asExpected,
+		},
+		{
+			name:     "storage is not configured",
+			cr:       newTestConfig(operatorv1.Managed),
+			err:      storage.ErrStorageNotConfigured,
+			expected: asExpected,
+		},
+		{
+			name:     "storage is not provisioned",
+			cr:       newTestConfig(operatorv1.Managed),
+			driver:   &fakeDriver{},
+			expected: asExpected,
+		},
+	} {
+		if err := indexer.Add(step.cr); err != nil {
+			t.Fatal(err)
+		}
+		drv, drvErr = step.driver, step.err
+
+		if err := c.sync(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+
+		_, status, _, _ := operatorClient.GetOperatorState()
+		cond := v1helpers.FindOperatorCondition(status.Conditions, storageDegradedConditionType)
+		if cond == nil {
+			t.Fatalf("%s: condition %s not found", step.name, storageDegradedConditionType)
+		}
+		step.expected.Type = storageDegradedConditionType
+		validateCondition(t, step.expected, *cond)
+	}
+}
+
+func TestStorageHealthControllerSyncKeepsCachedConfig(t *testing.T) {
+	cr := newTestConfig(operatorv1.Managed)
+	cr.Spec.Storage.GCS = &imageregistryv1.ImageRegistryConfigStorageGCS{
+		Bucket: "a-bucket",
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(cr); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &StorageHealthController{
+		operatorClient: v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil),
+		configLister:   imageregistryv1listers.NewConfigLister(indexer),
+		newDriver: func(cfg *imageregistryv1.ImageRegistryConfigStorage, _ *restclient.Config, _ *regopclient.StorageListers) (storage.Driver, error) {
+			// Drivers fill the missing parts of their configuration.
+			cfg.GCS.Region = "us-central1"
+			return &fakeDriver{id: "a-bucket", exists: true}, nil
+		},
+	}
+	if err := c.sync(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if region := cr.Spec.Storage.GCS.Region; region != "" {
+		t.Errorf("expected the cached config to be left unchanged, got region %q", region)
+	}
+}
+
+func TestCheckStorage(t *testing.T) {
+	fipsEnabled := true
+	for _, tt := range []struct {
+		name              string
+		objects           []runtime.Object
+		driver            storage.Driver
+		err               error
+		verifyPermissions bool
+		fipsEnabled       *bool
+		expected          operatorv1.OperatorCondition
+		errMsg            string
+	}{
+		{
+			name:   "config does not exist",
+			errMsg: "not found",
+		},
+		{
+			name:    "storage is not configured",
+			objects: []runtime.Object{newTestConfig(operatorv1.Managed)},
+			err:     storage.ErrStorageNotConfigured,
+			errMsg:  storage.ErrStorageNotConfigured.Error(),
+		},
+		{
+			name:    "storage is not provisioned",
+			objects: []runtime.Object{newTestConfig(operatorv1.Managed)},
+			driver:  &fakeDriver{},
+			errMsg:  "not provisioned",
+		},
+		{
+			name:    "storage configuration is not valid",
+			objects: []runtime.Object{newTestConfig(operatorv1.Managed)},
+			err:     &util.InvalidConfigurationError{Err: fmt.Errorf("unable to get the GCS project")},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "InvalidStorageConfiguration",
+				Message: "The storage configuration is not valid: unable to get the GCS project",
+			},
+		},
+		{
+			name:    "permissions are not verified",
+			objects: []runtime.Object{newTestConfig(operatorv1.Managed)},
+			driver: &fakeVerifyingDriver{
+				fakeDriver: fakeDriver{id: "a-bucket", exists: true},
+				verifyErr:  &util.InsufficientPermissionsError{Action: "s3:PutObject", Err: fmt.Errorf("access denied")},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status: operatorv1.ConditionFalse,
+				Reason: "AsExpected",
+			},
+		},
+		{
+			name:              "permissions are verified",
+			objects:           []runtime.Object{newTestConfig(operatorv1.Managed)},
+			verifyPermissions: true,
+			driver: &fakeVerifyingDriver{
+				fakeDriver: fakeDriver{id: "a-bucket", exists: true},
+				verifyErr:  &util.InsufficientPermissionsError{Action: "s3:PutObject", Err: fmt.Errorf("access denied")},
+			},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "InsufficientPermissions",
+				Message: "The storage credentials are not sufficient: missing s3:PutObject: access denied",
+			},
+		},
+		{
+			name:    "storage is accessible",
+			objects: []runtime.Object{newTestConfig(operatorv1.Managed)},
+			driver:  &fakeDriver{id: "a-bucket", exists: true},
+			expected: operatorv1.OperatorCondition{
+				Status: operatorv1.ConditionFalse,
+				Reason: "AsExpected",
+			},
+		},
+		{
+			name:        "FIPS mode is set",
+			objects:     []runtime.Object{newTestConfig(operatorv1.Managed)},
+			fipsEnabled: &fipsEnabled,
+			driver:      &fakeDriver{id: "a-bucket", exists: true},
+			expected: operatorv1.OperatorCondition{
+				Status: operatorv1.ConditionFalse,
+				Reason: "AsExpected",
+			},
+		},
+		{
+			name:    "storage check failed",
+			objects: []runtime.Object{newTestConfig(operatorv1.Managed)},
+			driver:  &fakeDriver{id: "a-bucket", err: fmt.Errorf("connection refused")},
+			expected: operatorv1.OperatorCondition{
+				Status:  operatorv1.ConditionTrue,
+				Reason:  "StorageCheckFailed",
+				Message: "Unable to check the storage backend: connection refused",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			newDriver := func(_ *imageregistryv1.ImageRegistryConfigStorage, _ *restclient.Config, listers *regopclient.StorageListers) (storage.Driver, error) {
+				if tt.fipsEnabled == nil {
+					if listers.FIPSEnabled != nil {
+						t.Error("expected the FIPS mode of the host to be used")
+					}
+				} else if fips, err := listers.FIPSEnabled(); err != nil || fips != *tt.fipsEnabled {
+					t.Errorf("expected FIPS mode %t, got %t, %v", *tt.fipsEnabled, fips, err)
+				}
+				return tt.driver, tt.err
+			}
+			cond, err := checkStorage(
+				ctx,
+				&restclient.Config{},
+				kubefakeclient.NewSimpleClientset(),
+				configfakeclient.NewSimpleClientset(),
+				imageregistryfakeclient.NewSimpleClientset(tt.objects...),
+				newDriver,
+				tt.verifyPermissions,
+				tt.fipsEnabled,
+			)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.expected.Type = storageDegradedConditionType
+			validateCondition(t, tt.expected, cond)
+		})
+	}
+}
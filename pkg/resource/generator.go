@@ -71,6 +71,7 @@ func NewGenerator(eventRecorder events.Recorder, kubeconfig *rest.Config, client
 		kubeconfig:    kubeconfig,
 		listers:       listers,
 		clients:       clients,
+		newDriver:     storage.NewDriver,
 	}
 }
 
@@ -79,6 +80,9 @@ type Generator struct {
 	kubeconfig    *rest.Config
 	listers       *client.Listers
 	clients       *client.Clients
+
+	// newDriver is storage.NewDriver, it is replaced during tests.
+	newDriver func(*imageregistryv1.ImageRegistryConfigStorage, *rest.Config, *client.StorageListers) (storage.Driver, error)
 }
 
 func (g *Generator) listRoutes(cr *imageregistryv1.Config) []Mutator {
@@ -95,7 +99,7 @@ func (g *Generator) listRoutes(cr *imageregistryv1.Config) []Mutator {
 }
 
 func (g *Generator) List(cr *imageregistryv1.Config) ([]Mutator, error) {
-	driver, err := storage.NewDriver(&cr.Spec.Storage, g.kubeconfig, &g.listers.StorageListers)
+	driver, err := g.newDriver(&cr.Spec.Storage, g.kubeconfig, &g.listers.StorageListers)
 	if err != nil && err != storage.ErrStorageNotConfigured {
 		return nil, err
 	} else if err == storage.ErrStorageNotConfigured {
@@ -125,13 +129,13 @@ func (g *Generator) List(cr *imageregistryv1.Config) ([]Mutator, error) {
 func (g *Generator) syncStorage(cr *imageregistryv1.Config) error {
 	var runCreate bool
 	// Create a driver with the current configuration
-	driver, err := storage.NewDriver(&cr.Spec.Storage, g.kubeconfig, &g.listers.StorageListers)
+	driver, err := g.newDriver(&cr.Spec.Storage, g.kubeconfig, &g.listers.StorageListers)
 	if err == storage.ErrStorageNotConfigured {
 		cr.Spec.Storage, _, err = storage.GetPlatformStorage(&g.listers.StorageListers)
 		if err != nil {
 			return fmt.Errorf("unable to get storage configuration from cluster install config: %s", err)
 		}
-		driver, err = storage.NewDriver(&cr.Spec.Storage, g.kubeconfig, &g.listers.StorageListers)
+		driver, err = g.newDriver(&cr.Spec.Storage, g.kubeconfig, &g.listers.StorageListers)
 	}
 	if err != nil {
 		return err
@@ -155,6 +159,10 @@ func (g *Generator) syncStorage(cr *imageregistryv1.Config) error {
 			return err
 		}
 		if reconf {
+			// The operator does not copy the data between storage backends,
+			// images pushed into the previous storage are not available
+			// anymore.
+			klog.Warningf("image registry storage has been reconfigured, images from the previous storage are not migrated")
 			metrics.StorageReconfigured()
 		}
 	}
@@ -225,7 +233,7 @@ func (g *Generator) Apply(cr *imageregistryv1.Config) error {
 	if err == storage.ErrStorageNotConfigured {
 		return err
 	} else if err != nil {
-		return fmt.Errorf("unable to sync storage configuration: %s", err)
+		return fmt.Errorf("unable to sync storage configuration: %w", err)
 	}
 
 	// XXX https://bugzilla.redhat.com/show_bug.cgi?id=1833109
@@ -289,7 +297,13 @@ func (g *Generator) Remove(cr *imageregistryv1.Config) error {
 		klog.Infof("object %s deleted", Name(gen))
 	}
 
-	driver, err := storage.NewDriver(&cr.Status.Storage, g.kubeconfig, &g.listers.StorageListers)
+	if cr.Annotations[defaults.PreserveStorageAnnotation] == "true" {
+		klog.Infof("storage is not removed as the %s annotation is set", defaults.PreserveStorageAnnotation)
+		cr.Status.Storage = imageregistryv1.ImageRegistryConfigStorage{}
+		return nil
+	}
+
+	driver, err := g.newDriver(&cr.Status.Storage, g.kubeconfig, &g.listers.StorageListers)
 	if err == storage.ErrStorageNotConfigured {
 		return nil
 	} else if err != nil {
@@ -0,0 +1,83 @@
+package resource
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+
+	"github.com/openshift/cluster-image-registry-operator/pkg/client"
+	cirofake "github.com/openshift/cluster-image-registry-operator/pkg/client/fake"
+	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage"
+)
+
+type removeStorageDriver struct {
+	testDriver
+	removed bool
+}
+
+func (d *removeStorageDriver) RemoveStorage(*imageregistryv1.Config) (bool, error) {
+	d.removed = true
+	return false, nil
+}
+
+func TestRemovePreserveStorage(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		annotations     map[string]string
+		expectedRemoved bool
+	}{
+		{
+			name:            "storage is removed",
+			expectedRemoved: true,
+		},
+		{
+			name: "storage is preserved",
+			annotations: map[string]string{
+				defaults.PreserveStorageAnnotation: "true",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &imageregistryv1.Config{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        defaults.ImageRegistryResourceName,
+					Annotations: tt.annotations,
+				},
+				Status: imageregistryv1.ImageRegistryStatus{
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						EmptyDir: &imageregistryv1.ImageRegistryConfigStorageEmptyDir{},
+					},
+				},
+			}
+
+			fixtures := cirofake.NewFixturesBuilder().Build()
+			kubeClient := fixtures.KubeClient
+			drv := &removeStorageDriver{}
+			g := NewGenerator(events.NewInMemoryRecorder("image-registry-operator"), &rest.Config{}, &client.Clients{
+				Kube: kubeClient,
+				Core: kubeClient.CoreV1(),
+				Apps: kubeClient.AppsV1(),
+				RBAC: kubeClient.RbacV1(),
+			}, fixtures.Listers)
+			g.newDriver = func(*imageregistryv1.ImageRegistryConfigStorage, *rest.Config, *client.StorageListers) (storage.Driver, error) {
+				return drv, nil
+			}
+
+			if err := g.Remove(cr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if drv.removed != tt.expectedRemoved {
+				t.Errorf("expected storage to be removed: %t, got %t", tt.expectedRemoved, drv.removed)
+			}
+			if cr.Status.Storage.EmptyDir != nil {
+				t.Errorf("expected the storage status to be reset, got %#v", cr.Status.Storage)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+
+	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+)
+
+func TestPodDisruptionBudgetMinAvailable(t *testing.T) {
+	for _, tt := range []struct {
+		replicas     int32
+		minAvailable intstr.IntOrString
+	}{
+		{replicas: 0, minAvailable: intstr.FromInt(0)},
+		{replicas: 1, minAvailable: intstr.FromInt(0)},
+		{replicas: 2, minAvailable: intstr.FromInt(1)},
+		{replicas: 5, minAvailable: intstr.FromInt(1)},
+	} {
+		cr := &imageregistryv1.Config{
+			Spec: imageregistryv1.ImageRegistrySpec{
+				Replicas: tt.replicas,
+			},
+		}
+		generator := newGeneratorPodDisruptionBudget(nil, nil, cr)
+		obj, err := generator.expected()
+		if err != nil {
+			t.Fatalf("error getting desired pod disruption budget: %v", err)
+		}
+		pdb, ok := obj.(*policyv1.PodDisruptionBudget)
+		if !ok {
+			t.Fatal("failed to cast object to PodDisruptionBudget")
+		}
+
+		if pdb.Spec.MinAvailable == nil || *pdb.Spec.MinAvailable != tt.minAvailable {
+			t.Errorf("replicas %d: got minAvailable %v, want %v", tt.replicas, pdb.Spec.MinAvailable, tt.minAvailable)
+		}
+		if pdb.Spec.Selector == nil || !reflect.DeepEqual(pdb.Spec.Selector.MatchLabels, defaults.DeploymentLabels) {
+			t.Errorf("replicas %d: expected the selector to match the registry pods, got %#v", tt.replicas, pdb.Spec.Selector)
+		}
+	}
+}
@@ -458,6 +458,40 @@ func makePodTemplateSpec(coreClient coreset.CoreV1Interface, proxyLister configl
 				},
 			},
 		}
+	} else if affinity == nil && cr.Spec.Replicas > 2 {
+		// with more replicas than nodes a hard requirement would block the
+		// rollout, so we only ask the scheduler to spread the pods across
+		// nodes and zones when it can.
+		affinity = &corev1.Affinity{
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+					{
+						Weight: 100,
+						PodAffinityTerm: corev1.PodAffinityTerm{
+							TopologyKey: "kubernetes.io/hostname",
+							Namespaces: []string{
+								defaults.ImageRegistryOperatorNamespace,
+							},
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: defaults.DeploymentLabels,
+							},
+						},
+					},
+					{
+						Weight: 50,
+						PodAffinityTerm: corev1.PodAffinityTerm{
+							TopologyKey: "topology.kubernetes.io/zone",
+							Namespaces: []string{
+								defaults.ImageRegistryOperatorNamespace,
+							},
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: defaults.DeploymentLabels,
+							},
+						},
+					},
+				},
+			},
+		}
 	}
 
 	nodeSelectors := map[string]string{}
@@ -225,6 +225,181 @@ func TestMakePodTemplateSpecWithTopologySpread(t *testing.T) {
 	}
 }
 
+func TestMakePodTemplateSpecWithNodePlacement(t *testing.T) {
+	infraToleration := corev1.Toleration{
+		Key:      "node-role.kubernetes.io/infra",
+		Operator: corev1.TolerationOpExists,
+		Effect:   corev1.TaintEffectNoSchedule,
+	}
+	tests := map[string]struct {
+		spec                v1.ImageRegistrySpec
+		expectedSelector    map[string]string
+		expectedTolerations []corev1.Toleration
+	}{
+		"testDefaultNodeSelector": {
+			spec: v1.ImageRegistrySpec{},
+			expectedSelector: map[string]string{
+				"kubernetes.io/os": "linux",
+			},
+		},
+		"testUserDefinedNodeSelectorAndTolerations": {
+			spec: v1.ImageRegistrySpec{
+				NodeSelector: map[string]string{
+					"node-role.kubernetes.io/infra": "",
+				},
+				Tolerations: []corev1.Toleration{infraToleration},
+			},
+			expectedSelector: map[string]string{
+				"node-role.kubernetes.io/infra": "",
+				"kubernetes.io/os":              "linux",
+			},
+			expectedTolerations: []corev1.Toleration{infraToleration},
+		},
+		"testUserDefinedOperatingSystem": {
+			spec: v1.ImageRegistrySpec{
+				NodeSelector: map[string]string{
+					"kubernetes.io/os": "custom",
+				},
+			},
+			expectedSelector: map[string]string{
+				"kubernetes.io/os": "custom",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := &v1.Config{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+				Spec: tc.spec,
+			}
+			fixture := buildFakeClient(config, nil)
+			emptyDirStorage := emptydir.NewDriver(&v1.ImageRegistryConfigStorageEmptyDir{})
+			pod, _, err := makePodTemplateSpec(
+				fixture.KubeClient.CoreV1(),
+				fixture.Listers.ProxyConfigs,
+				emptyDirStorage,
+				config,
+			)
+			if err != nil {
+				t.Fatalf("error creating pod template: %v", err)
+			}
+			if !reflect.DeepEqual(pod.Spec.NodeSelector, tc.expectedSelector) {
+				t.Errorf("unexpected node selector: got %#v, want %#v", pod.Spec.NodeSelector, tc.expectedSelector)
+			}
+			if !reflect.DeepEqual(pod.Spec.Tolerations, tc.expectedTolerations) {
+				t.Errorf("unexpected tolerations: got %#v, want %#v", pod.Spec.Tolerations, tc.expectedTolerations)
+			}
+		})
+	}
+}
+
+func TestMakePodTemplateSpecWithAffinity(t *testing.T) {
+	registryPodsTerm := func(topologyKey string) corev1.PodAffinityTerm {
+		return corev1.PodAffinityTerm{
+			TopologyKey: topologyKey,
+			Namespaces: []string{
+				defaults.ImageRegistryOperatorNamespace,
+			},
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: defaults.DeploymentLabels,
+			},
+		}
+	}
+	userAffinity := &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      "node-role.kubernetes.io/infra",
+								Operator: corev1.NodeSelectorOpExists,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	tests := map[string]struct {
+		spec     v1.ImageRegistrySpec
+		expected *corev1.Affinity
+	}{
+		"testSingleReplica": {
+			spec: v1.ImageRegistrySpec{
+				Replicas: 1,
+			},
+			expected: nil,
+		},
+		"testTwoReplicasRequireDifferentNodes": {
+			spec: v1.ImageRegistrySpec{
+				Replicas: 2,
+			},
+			expected: &corev1.Affinity{
+				PodAntiAffinity: &corev1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+						registryPodsTerm("kubernetes.io/hostname"),
+					},
+				},
+			},
+		},
+		"testMoreReplicasPreferDifferentNodesAndZones": {
+			spec: v1.ImageRegistrySpec{
+				Replicas: 3,
+			},
+			expected: &corev1.Affinity{
+				PodAntiAffinity: &corev1.PodAntiAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+						{
+							Weight:          100,
+							PodAffinityTerm: registryPodsTerm("kubernetes.io/hostname"),
+						},
+						{
+							Weight:          50,
+							PodAffinityTerm: registryPodsTerm("topology.kubernetes.io/zone"),
+						},
+					},
+				},
+			},
+		},
+		"testUserDefinedOverridesDefaults": {
+			spec: v1.ImageRegistrySpec{
+				Replicas: 3,
+				Affinity: userAffinity,
+			},
+			expected: userAffinity,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := &v1.Config{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+				Spec: tc.spec,
+			}
+			fixture := buildFakeClient(config, nil)
+			emptyDirStorage := emptydir.NewDriver(&v1.ImageRegistryConfigStorageEmptyDir{})
+			pod, _, err := makePodTemplateSpec(
+				fixture.KubeClient.CoreV1(),
+				fixture.Listers.ProxyConfigs,
+				emptyDirStorage,
+				config,
+			)
+			if err != nil {
+				t.Fatalf("error creating pod template: %v", err)
+			}
+			if !reflect.DeepEqual(pod.Spec.Affinity, tc.expected) {
+				t.Logf("want: %#v", tc.expected)
+				t.Logf("got:  %#v", pod.Spec.Affinity)
+				t.Fatalf("wrong pod affinity")
+			}
+		})
+	}
+}
+
 type volumeMount struct {
 	volExists   bool
 	mountExists bool
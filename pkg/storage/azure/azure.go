@@ -2,6 +2,7 @@ package azure
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -31,6 +32,7 @@ import (
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
 	operatorapiv1 "github.com/openshift/api/operator/v1"
 	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 
 	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
@@ -48,6 +50,10 @@ const (
 	storageExistsReasonContainerExists   = "ContainerExists"
 	storageExistsReasonContainerDeleted  = "ContainerDeleted"
 	storageExistsReasonAccountDeleted    = "AccountDeleted"
+
+	storageExistsReasonAuthenticationFailed = "AuthenticationFailed"
+	storageExistsReasonAuthorizationFailed  = "AuthorizationFailed"
+	storageExistsReasonAccountDisabled      = "AccountDisabled"
 )
 
 // storageAccountInvalidCharRe is a regular expression for characters that
@@ -123,13 +129,15 @@ func GetConfig(secLister kcorelisters.SecretNamespaceLister, infraLister configl
 	// loads user provided account key.
 	key, err := util.GetValueFromSecret(sec, "REGISTRY_STORAGE_AZURE_ACCOUNTKEY")
 	if err != nil {
-		return nil, err
+		return nil, &util.InvalidConfigurationError{Err: err}
 	} else if key == "" {
-		return nil, fmt.Errorf("the secret %s/%s has an empty value for "+
-			"REGISTRY_STORAGE_AZURE_ACCOUNTKEY; the secret should be removed so that "+
-			"the operator can use cluster-wide secrets or it should contain a valid "+
-			"storage account access key", sec.Namespace, sec.Name,
-		)
+		return nil, &util.InvalidConfigurationError{
+			Err: fmt.Errorf("the secret %s/%s has an empty value for "+
+				"REGISTRY_STORAGE_AZURE_ACCOUNTKEY; the secret should be removed so that "+
+				"the operator can use cluster-wide secrets or it should contain a valid "+
+				"storage account access key", sec.Namespace, sec.Name,
+			),
+		}
 	}
 
 	return &Azure{
@@ -184,7 +192,7 @@ func (d *driver) createStorageAccount(storageAccountsClient storage.AccountsClie
 		MinimumTLSVersion:      storage.TLS12,
 	}
 
-	if strings.EqualFold(cloudName, "AZURESTACKCLOUD") {
+	if isAzureStackHub(cloudName) {
 		// It seems Azure Stack Hub does not support new API.
 		kind = storage.Storage
 		params = &storage.AccountPropertiesCreateParameters{}
@@ -495,12 +503,138 @@ func (d *driver) containerExists(ctx context.Context, environment autorestazure.
 		}
 	}
 	if err != nil {
-		return false, fmt.Errorf("unable to get the storage container %s: %s", containerName, err)
+		return false, fmt.Errorf("unable to get the storage container %s: %w", containerName, err)
 	}
 
 	return true, nil
 }
 
+// isAzureStackHub returns true if cloudName refers to Azure Stack Hub.
+func isAzureStackHub(cloudName string) bool {
+	return strings.EqualFold(cloudName, "AZURESTACKCLOUD")
+}
+
+// secureTransportEnforced returns true if the storage account only accepts
+// HTTPS connections with TLS 1.2 or newer.
+func secureTransportEnforced(account storage.Account) bool {
+	props := account.AccountProperties
+	return props != nil &&
+		to.Bool(props.EnableHTTPSTrafficOnly) &&
+		props.MinimumTLSVersion == storage.TLS12
+}
+
+// managesSecureTransport returns true if the operator is responsible for the
+// transport settings of the storage account.
+func (d *driver) managesSecureTransport(cr *imageregistryv1.Config, cfg *Azure) bool {
+	// User provided account keys don't allow to use the management API, and
+	// Azure Stack Hub doesn't support these settings.
+	return cr.Spec.Storage.ManagementState == imageregistryv1.StorageManagementStateManaged &&
+		cfg.AccountKey == "" &&
+		!isAzureStackHub(d.Config.CloudName)
+}
+
+// secureTransportCheckInterval is how often the transport settings of the
+// storage account are checked for drift and how long the operator waits
+// before it tries to enforce them again after a failure.
+const secureTransportCheckInterval = 20 * time.Minute
+
+// secureTransportCheckKey identifies the transport settings check of the
+// storage account in the check schedule.
+func secureTransportCheckKey(resourceGroup, account string) string {
+	return "azure/secure-transport/" + resourceGroup + "/" + account
+}
+
+// checkSecureTransport updates the StorageSecureTransportEnforced condition
+// according to the current settings of the storage account, so that
+// StorageChanged can detect drift. The settings are checked at most once per
+// secureTransportCheckInterval, the condition is left as is in between. They
+// are not checked when the driver has no check schedule, e.g. when it is used
+// to probe the storage: the probe doesn't keep the condition.
+func (d *driver) checkSecureTransport(cr *imageregistryv1.Config, cfg *Azure, environment autorestazure.Environment) {
+	if d.Listers == nil || d.Listers.CheckSchedule == nil {
+		return
+	}
+	key := secureTransportCheckKey(cfg.ResourceGroup, d.Config.AccountName)
+	if !d.Listers.CheckSchedule.Due(key) {
+		return
+	}
+	d.Listers.CheckSchedule.Postpone(key, secureTransportCheckInterval)
+
+	storageAccountsClient, err := d.storageAccountsClient(cfg, environment)
+	if err != nil {
+		klog.Warningf("unable to check transport settings of storage account %s: %s", d.Config.AccountName, err)
+		return
+	}
+
+	account, err := storageAccountsClient.GetProperties(d.Context, cfg.ResourceGroup, d.Config.AccountName, "")
+	if err != nil {
+		klog.Warningf("unable to check transport settings of storage account %s: %s", d.Config.AccountName, err)
+		return
+	}
+
+	if !secureTransportEnforced(account) {
+		util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, operatorapiv1.ConditionFalse, "Secure Transport Not Enforced", "The storage account accepts HTTP connections or TLS versions older than 1.2")
+		return
+	}
+	util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, operatorapiv1.ConditionTrue, "Secure Transport Enforced", "The storage account only accepts HTTPS connections with TLS 1.2 or newer")
+}
+
+// enforceSecureTransport makes sure the storage account only accepts HTTPS
+// connections with TLS 1.2 or newer. If the settings cannot be changed, e.g.
+// because of missing permissions or an Azure Policy, the condition is set to
+// Unknown so that StorageChanged doesn't retry on every sync. The next attempt
+// is made when the drift check runs again.
+func (d *driver) enforceSecureTransport(cr *imageregistryv1.Config, cfg *Azure, storageAccountsClient storage.AccountsClient, accountName string) {
+	if d.Listers != nil && d.Listers.CheckSchedule != nil {
+		d.Listers.CheckSchedule.Postpone(secureTransportCheckKey(cfg.ResourceGroup, accountName), secureTransportCheckInterval)
+	}
+
+	account, err := storageAccountsClient.GetProperties(d.Context, cfg.ResourceGroup, accountName, "")
+	if err != nil {
+		util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, operatorapiv1.ConditionUnknown, storageExistsReasonAzureError, fmt.Sprintf("Unable to get storage account properties: %s", err))
+		return
+	}
+	if secureTransportEnforced(account) {
+		util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, operatorapiv1.ConditionTrue, "Secure Transport Enforced", "The storage account only accepts HTTPS connections with TLS 1.2 or newer")
+		return
+	}
+
+	klog.Infof("enforcing HTTPS and TLS 1.2 on storage account %s", accountName)
+	_, err = storageAccountsClient.Update(d.Context, cfg.ResourceGroup, accountName, storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{
+			EnableHTTPSTrafficOnly: to.BoolPtr(true),
+			MinimumTLSVersion:      storage.TLS12,
+		},
+	})
+	if err != nil {
+		util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, operatorapiv1.ConditionUnknown, storageExistsReasonAzureError, fmt.Sprintf("Unable to update storage account: %s", err))
+		return
+	}
+	util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, operatorapiv1.ConditionTrue, "Secure Transport Enforced", "The storage account only accepts HTTPS connections with TLS 1.2 or newer")
+}
+
+// accessErrorReason returns the StorageExists reason for errors that mean
+// that the container cannot be accessed with the current credentials or
+// network settings. It returns an empty string for other errors.
+func accessErrorReason(err error) string {
+	var serr azblob.StorageError
+	if !goerrors.As(err, &serr) {
+		return ""
+	}
+	switch serr.ServiceCode() {
+	case azblob.ServiceCodeAuthenticationFailed:
+		// The account key was rotated or revoked, or the SAS token expired.
+		return storageExistsReasonAuthenticationFailed
+	case "AuthorizationFailure", "AuthorizationPermissionMismatch":
+		// The request is blocked by the storage account firewall or the
+		// credentials lack a role assignment.
+		return storageExistsReasonAuthorizationFailed
+	case azblob.ServiceCodeAccountIsDisabled:
+		return storageExistsReasonAccountDisabled
+	}
+	return ""
+}
+
 // StorageExists checks if the storage container exists and is accessible.
 func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 	if d.Config.AccountName == "" || d.Config.Container == "" {
@@ -527,7 +661,10 @@ func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 	}
 
 	exists, err := d.containerExists(d.Context, environment, d.Config.AccountName, key, d.Config.Container)
-	if err != nil {
+	if reason := accessErrorReason(err); reason != "" {
+		util.UpdateCondition(cr, defaults.StorageExists, operatorapiv1.ConditionFalse, reason, fmt.Sprintf("%s", err))
+		return false, err
+	} else if err != nil {
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapiv1.ConditionUnknown, storageExistsReasonAzureError, fmt.Sprintf("%s", err))
 		return false, err
 	}
@@ -537,12 +674,33 @@ func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 	}
 
 	util.UpdateCondition(cr, defaults.StorageExists, operatorapiv1.ConditionTrue, storageExistsReasonContainerExists, "Storage container exists")
+
+	if d.managesSecureTransport(cr, cfg) {
+		d.checkSecureTransport(cr, cfg, environment)
+	}
+
 	return true, nil
 }
 
 // StorageChanged checks if the storage configuration has changed.
 func (d *driver) StorageChanged(cr *imageregistryv1.Config) bool {
-	return !reflect.DeepEqual(cr.Status.Storage.Azure, cr.Spec.Storage.Azure)
+	if !reflect.DeepEqual(cr.Status.Storage.Azure, cr.Spec.Storage.Azure) {
+		return true
+	}
+
+	// The transport settings of the managed storage account drifted and
+	// have to be reconciled. CreateStorage enforces them only when the
+	// operator manages them.
+	if cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageSecureTransportEnforced); cond != nil && cond.Status == operatorapiv1.ConditionFalse {
+		cfg, err := GetConfig(d.Listers.Secrets, d.Listers.Infrastructures)
+		if err != nil {
+			klog.Warningf("unable to get Azure configuration: %s", err)
+			return false
+		}
+		return d.managesSecureTransport(cr, cfg)
+	}
+
+	return false
 }
 
 func (d *driver) assurePrivateAccount(cfg *Azure, infra *configv1.Infrastructure, tagset map[string]*string, accountName string) (string, error) {
@@ -907,6 +1065,20 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 		}
 	}
 
+	if storageAccountCreated && !isAzureStackHub(d.Config.CloudName) {
+		util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, operatorapiv1.ConditionTrue, "Secure Transport Enforced", "The storage account only accepts HTTPS connections with TLS 1.2 or newer")
+	} else if !storageAccountCreated && d.managesSecureTransport(cr, cfg) {
+		environment, err := getEnvironmentByName(d.Config.CloudName)
+		if err != nil {
+			return err
+		}
+		storageAccountsClient, err := d.storageAccountsClient(cfg, environment)
+		if err != nil {
+			return err
+		}
+		d.enforceSecureTransport(cr, cfg, storageAccountsClient, storageAccountName)
+	}
+
 	cr.Spec.Storage.Azure = d.Config.DeepCopy()
 	cr.Status.Storage = imageregistryv1.ImageRegistryConfigStorage{
 		Azure: d.Config.DeepCopy(),
@@ -5,9 +5,12 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
@@ -30,17 +33,20 @@ import (
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
 	operatorapiv1 "github.com/openshift/api/operator/v1"
 
+	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
 	cirofake "github.com/openshift/cluster-image-registry-operator/pkg/client/fake"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
 	"github.com/openshift/cluster-image-registry-operator/pkg/envvar"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 )
 
 func TestGetConfig(t *testing.T) {
 	for _, tt := range []struct {
-		name    string
-		secrets []runtime.Object
-		err     string
-		result  *Azure
+		name          string
+		secrets       []runtime.Object
+		err           string
+		invalidConfig bool
+		result        *Azure
 	}{
 		{
 			name: "no secrets",
@@ -60,6 +66,7 @@ func TestGetConfig(t *testing.T) {
 			},
 			err: `secret "test/image-registry-private-configuration-user" does not ` +
 				`contain required key "REGISTRY_STORAGE_AZURE_ACCOUNTKEY"`,
+			invalidConfig: true,
 		},
 		{
 			name: "empty REGISTRY_STORAGE_AZURE_ACCOUNTKEY",
@@ -78,6 +85,7 @@ func TestGetConfig(t *testing.T) {
 				`empty value for REGISTRY_STORAGE_AZURE_ACCOUNTKEY; the secret ` +
 				`should be removed so that the operator can use cluster-wide ` +
 				`secrets or it should contain a valid storage account access key`,
+			invalidConfig: true,
 		},
 		{
 			name: "valid user provided secret",
@@ -198,6 +206,10 @@ func TestGetConfig(t *testing.T) {
 				if tt.err != err.Error() {
 					t.Errorf("expected err %q, %q received instead", tt.err, err)
 				}
+				var invalidConfigErr *util.InvalidConfigurationError
+				if errors.As(err, &invalidConfigErr) != tt.invalidConfig {
+					t.Errorf("expected invalid configuration error to be %t, got %#+v", tt.invalidConfig, err)
+				}
 				return
 			}
 			if err != nil {
@@ -252,6 +264,34 @@ func TestGenerateAccountName(t *testing.T) {
 	}
 }
 
+func TestGetEnvironmentByNameAzureStackCloud(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "azurestackcloud.json")
+	if err := os.WriteFile(filename, []byte(`{
+		"name": "AzureStackCloud",
+		"resourceManagerEndpoint": "https://management.local.azurestack.external/",
+		"storageEndpointSuffix": "local.azurestack.external"
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AZURE_ENVIRONMENT_FILEPATH", filename)
+
+	environment, err := getEnvironmentByName("AzureStackCloud")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if environment.ResourceManagerEndpoint != "https://management.local.azurestack.external/" {
+		t.Errorf("unexpected resource manager endpoint: %q", environment.ResourceManagerEndpoint)
+	}
+
+	u, err := getBlobServiceURL(environment, "account")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "https://account.blob.local.azurestack.external"; u.String() != expected {
+		t.Errorf("expected blob service URL %q, got %q", expected, u)
+	}
+}
+
 func findEnvVar(envvars envvar.List, name string) *envvar.EnvVar {
 	for i, e := range envvars {
 		if e.Name == name {
@@ -1399,3 +1439,369 @@ func Test_storageManagementState(t *testing.T) {
 		})
 	}
 }
+
+func TestStorageExistsAccessErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		errorCode string
+		status    operatorapiv1.ConditionStatus
+		reason    string
+	}{
+		{
+			name:      "revoked account key",
+			errorCode: "AuthenticationFailed",
+			status:    operatorapiv1.ConditionFalse,
+			reason:    storageExistsReasonAuthenticationFailed,
+		},
+		{
+			name:      "blocked by the storage account firewall",
+			errorCode: "AuthorizationFailure",
+			status:    operatorapiv1.ConditionFalse,
+			reason:    storageExistsReasonAuthorizationFailed,
+		},
+		{
+			name:      "disabled account",
+			errorCode: "AccountIsDisabled",
+			status:    operatorapiv1.ConditionFalse,
+			reason:    storageExistsReasonAccountDisabled,
+		},
+		{
+			name:      "unexpected error",
+			errorCode: "InternalError",
+			status:    operatorapiv1.ConditionUnknown,
+			reason:    storageExistsReasonAzureError,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := cirofake.NewFixturesBuilder()
+			builder.AddInfraConfig(&configv1.Infrastructure{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+				Status: configv1.InfrastructureStatus{
+					PlatformStatus: &configv1.PlatformStatus{
+						Type:  configv1.AzurePlatformType,
+						Azure: &configv1.AzurePlatformStatus{},
+					},
+				},
+			})
+			builder.AddSecrets(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      defaults.ImageRegistryPrivateConfigurationUser,
+					Namespace: defaults.ImageRegistryOperatorNamespace,
+				},
+				Data: map[string][]byte{
+					"REGISTRY_STORAGE_AZURE_ACCOUNTKEY": []byte(base64.StdEncoding.EncodeToString([]byte("account_key"))),
+				},
+			})
+			listers := builder.BuildListers()
+
+			cr := &imageregistryv1.Config{}
+			drv := NewDriver(context.Background(), &imageregistryv1.ImageRegistryConfigStorageAzure{
+				AccountName: "account",
+				Container:   "container",
+			}, &listers.StorageListers)
+			drv.httpSender = pipeline.FactoryFunc(
+				func(_ pipeline.Policy, _ *pipeline.PolicyOptions) pipeline.PolicyFunc {
+					return func(_ context.Context, _ pipeline.Request) (pipeline.Response, error) {
+						resp := mocks.NewResponseWithStatus("Forbidden", http.StatusForbidden)
+						resp.Header = map[string][]string{}
+						resp.Header.Add("x-ms-error-code", tt.errorCode)
+						return pipeline.NewHTTPResponse(resp), nil
+					}
+				},
+			)
+
+			exists, err := drv.StorageExists(cr)
+			if err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if exists {
+				t.Error("expected the storage to be reported as not existing")
+			}
+
+			cond := util.FetchCondition(cr, defaults.StorageExists)
+			if cond.Status != tt.status || cond.Reason != tt.reason {
+				t.Errorf("expected condition %s/%s, got %s/%s", tt.status, tt.reason, cond.Status, cond.Reason)
+			}
+		})
+	}
+}
+
+func Test_enforceSecureTransport(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		mockResponses []*http.Response
+		attempts      int
+		status        operatorapiv1.ConditionStatus
+	}{
+		{
+			name: "account already enforces secure transport",
+			mockResponses: []*http.Response{
+				mocks.NewResponseWithContent(`{"properties":{"supportsHttpsTrafficOnly":true,"minimumTlsVersion":"TLS1_2"}}`),
+			},
+			attempts: 1,
+			status:   operatorapiv1.ConditionTrue,
+		},
+		{
+			name: "account allows plain HTTP",
+			mockResponses: []*http.Response{
+				mocks.NewResponseWithContent(`{"properties":{"supportsHttpsTrafficOnly":false,"minimumTlsVersion":"TLS1_2"}}`),
+				mocks.NewResponseWithContent(`{}`),
+			},
+			attempts: 2,
+			status:   operatorapiv1.ConditionTrue,
+		},
+		{
+			name: "account allows old TLS versions",
+			mockResponses: []*http.Response{
+				mocks.NewResponseWithContent(`{"properties":{"supportsHttpsTrafficOnly":true,"minimumTlsVersion":"TLS1_0"}}`),
+				mocks.NewResponseWithContent(`{}`),
+			},
+			attempts: 2,
+			status:   operatorapiv1.ConditionTrue,
+		},
+		{
+			name: "update fails",
+			mockResponses: []*http.Response{
+				mocks.NewResponseWithContent(`{"properties":{"supportsHttpsTrafficOnly":false}}`),
+				mocks.NewResponseWithStatus("forbidden", http.StatusForbidden),
+			},
+			attempts: 2,
+			status:   operatorapiv1.ConditionUnknown,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := mocks.NewSender()
+			for _, response := range tt.mockResponses {
+				sender.AppendResponse(response)
+			}
+
+			drv := NewDriver(context.Background(), &imageregistryv1.ImageRegistryConfigStorageAzure{
+				AccountName: "account",
+			}, nil)
+			drv.authorizer = autorest.NullAuthorizer{}
+			drv.sender = sender
+
+			cfg := &Azure{
+				SubscriptionID: "subscription_id",
+				ResourceGroup:  "resource_group",
+			}
+			environment, err := getEnvironmentByName("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := drv.storageAccountsClient(cfg, environment)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cr := &imageregistryv1.Config{}
+			drv.enforceSecureTransport(cr, cfg, client, "account")
+
+			if sender.Attempts() != tt.attempts {
+				t.Errorf("expected %d requests, got %d", tt.attempts, sender.Attempts())
+			}
+			cond := util.FetchCondition(cr, defaults.StorageSecureTransportEnforced)
+			if cond.Status != tt.status {
+				t.Errorf("expected condition status %s, got %#+v", tt.status, cond)
+			}
+		})
+	}
+}
+
+// newSecureTransportListers returns listers for a cluster with minted
+// credentials, or with a user provided account key if accountKey is set.
+func newSecureTransportListers(accountKey bool) *regopclient.StorageListers {
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AzurePlatformType,
+				Azure: &configv1.AzurePlatformStatus{
+					ResourceGroupName: "resource_group",
+				},
+			},
+		},
+	})
+	if accountKey {
+		builder.AddSecrets(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      defaults.ImageRegistryPrivateConfigurationUser,
+				Namespace: defaults.ImageRegistryOperatorNamespace,
+			},
+			Data: map[string][]byte{
+				"REGISTRY_STORAGE_AZURE_ACCOUNTKEY": []byte(base64.StdEncoding.EncodeToString([]byte("account_key"))),
+			},
+		})
+	} else {
+		builder.AddSecrets(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      defaults.CloudCredentialsName,
+				Namespace: defaults.ImageRegistryOperatorNamespace,
+			},
+			Data: map[string][]byte{
+				"azure_subscription_id": []byte("subscription_id"),
+				"azure_resourcegroup":   []byte("resource_group"),
+			},
+		})
+	}
+	listers := builder.BuildListers()
+	return &listers.StorageListers
+}
+
+func Test_checkSecureTransport(t *testing.T) {
+	config := &imageregistryv1.ImageRegistryConfigStorageAzure{
+		AccountName: "account",
+		Container:   "container",
+	}
+	cfg := &Azure{
+		SubscriptionID: "subscription_id",
+		ResourceGroup:  "resource_group",
+	}
+	environment, err := getEnvironmentByName("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConfig := func() *imageregistryv1.Config {
+		return &imageregistryv1.Config{
+			Spec: imageregistryv1.ImageRegistrySpec{
+				Storage: imageregistryv1.ImageRegistryConfigStorage{
+					ManagementState: imageregistryv1.StorageManagementStateManaged,
+					Azure:           config,
+				},
+			},
+			Status: imageregistryv1.ImageRegistryStatus{
+				Storage: imageregistryv1.ImageRegistryConfigStorage{
+					Azure: config.DeepCopy(),
+				},
+			},
+		}
+	}
+
+	t.Run("storage probe", func(t *testing.T) {
+		sender := mocks.NewSender()
+
+		// The storage probe doesn't keep the condition, it shouldn't
+		// delay the check of the controller that reconciles the storage.
+		drv := NewDriver(context.Background(), config, newSecureTransportListers(false))
+		drv.authorizer = autorest.NullAuthorizer{}
+		drv.sender = sender
+
+		drv.checkSecureTransport(newConfig(), cfg, environment)
+		if sender.Attempts() != 0 {
+			t.Errorf("expected no requests without a check schedule, got %d", sender.Attempts())
+		}
+	})
+
+	t.Run("controller", func(t *testing.T) {
+		sender := mocks.NewSender()
+		sender.AppendResponse(mocks.NewResponseWithContent(`{"properties":{"supportsHttpsTrafficOnly":false,"minimumTlsVersion":"TLS1_2"}}`))
+		sender.AppendResponse(mocks.NewResponseWithContent(`{"properties":{"supportsHttpsTrafficOnly":false,"minimumTlsVersion":"TLS1_2"}}`))
+		sender.AppendResponse(mocks.NewResponseWithStatus("forbidden", http.StatusForbidden))
+
+		listers := newSecureTransportListers(false)
+		listers.CheckSchedule = regopclient.NewCheckSchedule()
+		drv := NewDriver(context.Background(), config, listers)
+		drv.authorizer = autorest.NullAuthorizer{}
+		drv.sender = sender
+
+		cr := newConfig()
+		drv.checkSecureTransport(cr, cfg, environment)
+		if sender.Attempts() != 1 {
+			t.Fatalf("expected the account settings to be checked, got %d requests", sender.Attempts())
+		}
+		if !drv.StorageChanged(cr) {
+			t.Fatal("expected the drifted settings to be reconciled")
+		}
+
+		// The account settings cannot be changed.
+		client, err := drv.storageAccountsClient(cfg, environment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drv.enforceSecureTransport(cr, cfg, client, "account")
+		if cond := util.FetchCondition(cr, defaults.StorageSecureTransportEnforced); cond.Status != operatorapiv1.ConditionUnknown {
+			t.Errorf("expected condition status %s, got %#+v", operatorapiv1.ConditionUnknown, cond)
+		}
+		if drv.StorageChanged(cr) {
+			t.Error("expected the failed update not to be retried on every sync")
+		}
+
+		// The settings are not checked again until the interval passes,
+		// also by drivers that are created for the next syncs.
+		attempts := sender.Attempts()
+		drv = NewDriver(context.Background(), config, listers)
+		drv.authorizer = autorest.NullAuthorizer{}
+		drv.sender = sender
+		drv.checkSecureTransport(cr, cfg, environment)
+		if sender.Attempts() != attempts {
+			t.Errorf("expected no requests before the next check is due, got %d", sender.Attempts()-attempts)
+		}
+		if drv.StorageChanged(cr) {
+			t.Error("expected the failed update not to be retried before the next check is due")
+		}
+	})
+}
+
+func TestStorageChangedSecureTransport(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		managementState string
+		accountKey      bool
+		status          operatorapiv1.ConditionStatus
+		changed         bool
+	}{
+		{
+			name:            "managed account with drifted settings",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			status:          operatorapiv1.ConditionFalse,
+			changed:         true,
+		},
+		{
+			name:            "managed account with expected settings",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			status:          operatorapiv1.ConditionTrue,
+		},
+		{
+			name:            "unmanaged account with drifted settings",
+			managementState: imageregistryv1.StorageManagementStateUnmanaged,
+			status:          operatorapiv1.ConditionFalse,
+		},
+		{
+			name:            "account key added after drift was detected",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			accountKey:      true,
+			status:          operatorapiv1.ConditionFalse,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &imageregistryv1.ImageRegistryConfigStorageAzure{
+				AccountName: "account",
+				Container:   "container",
+			}
+			cr := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						ManagementState: tt.managementState,
+						Azure:           config,
+					},
+				},
+				Status: imageregistryv1.ImageRegistryStatus{
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						Azure: config.DeepCopy(),
+					},
+				},
+			}
+			util.UpdateCondition(cr, defaults.StorageSecureTransportEnforced, tt.status, "", "")
+
+			drv := NewDriver(context.Background(), config, newSecureTransportListers(tt.accountKey))
+			if changed := drv.StorageChanged(cr); changed != tt.changed {
+				t.Errorf("expected StorageChanged to be %t, got %t", tt.changed, changed)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	configapiv1 "github.com/openshift/api/config/v1"
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
 	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 
 	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
@@ -114,7 +115,9 @@ func GetConfig(listers *regopclient.StorageListers) (*GCS, error) {
 		if v, ok := sec.Data["REGISTRY_STORAGE_GCS_KEYFILE"]; ok {
 			gcsConfig.KeyfileData = string(v)
 		} else {
-			return nil, fmt.Errorf("secret %q does not contain required key \"REGISTRY_STORAGE_GCS_KEYFILE\"", fmt.Sprintf("%s/%s", defaults.ImageRegistryOperatorNamespace, defaults.ImageRegistryPrivateConfigurationUser))
+			return nil, &util.InvalidConfigurationError{
+				Err: fmt.Errorf("secret %q does not contain required key \"REGISTRY_STORAGE_GCS_KEYFILE\"", fmt.Sprintf("%s/%s", defaults.ImageRegistryOperatorNamespace, defaults.ImageRegistryPrivateConfigurationUser)),
+			}
 		}
 	}
 
@@ -172,23 +175,70 @@ func (d *driver) VolumeSecrets() (map[string]string, error) {
 	}, nil
 }
 
-func (d *driver) bucketExists(bucketName string) error {
+func (d *driver) bucketAttrs(bucketName string) (*gstorage.BucketAttrs, error) {
 	client, err := d.getGCSClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = client.Bucket(d.Config.Bucket).Attrs(d.Context)
+	return client.Bucket(bucketName).Attrs(d.Context)
+}
 
+func (d *driver) bucketExists(bucketName string) error {
+	_, err := d.bucketAttrs(bucketName)
 	return err
 }
 
+// publicAccessNotBlockedReason is the StoragePublicAccessBlocked reason when
+// the settings of the managed bucket drifted and have to be reconciled.
+const publicAccessNotBlockedReason = "Public Access Not Blocked"
+
+// publicAccessBlockDisabled returns true if the registry config opts out of
+// blocking public access to the buckets that the operator creates.
+func publicAccessBlockDisabled(cr *imageregistryv1.Config) bool {
+	return cr.Annotations[defaults.BlockPublicAccessAnnotation] == "false"
+}
+
+// blocksPublicAccess returns true if the operator is responsible for blocking
+// public access to the existing bucket.
+func blocksPublicAccess(cr *imageregistryv1.Config) bool {
+	return cr.Spec.Storage.ManagementState == imageregistryv1.StorageManagementStateManaged &&
+		!publicAccessBlockDisabled(cr)
+}
+
+// publicAccessBlocked returns true if only IAM permissions grant access to
+// the bucket and public access to it is prevented.
+func publicAccessBlocked(attrs *gstorage.BucketAttrs) bool {
+	return attrs.UniformBucketLevelAccess.Enabled &&
+		attrs.PublicAccessPrevention == gstorage.PublicAccessPreventionEnforced
+}
+
+// blockPublicAccess enables uniform bucket-level access and enforces public
+// access prevention on the bucket.
+func (d *driver) blockPublicAccess(cr *imageregistryv1.Config, bucket *gstorage.BucketHandle) {
+	_, err := bucket.Update(d.Context, gstorage.BucketAttrsToUpdate{
+		UniformBucketLevelAccess: &gstorage.UniformBucketLevelAccess{
+			Enabled: true,
+		},
+		PublicAccessPrevention: gstorage.PublicAccessPreventionEnforced,
+	})
+	if err != nil {
+		if gerr, ok := err.(*gapi.Error); ok {
+			util.UpdateCondition(cr, defaults.StoragePublicAccessBlocked, operatorapi.ConditionFalse, strconv.Itoa(gerr.Code), gerr.Error())
+		} else {
+			util.UpdateCondition(cr, defaults.StoragePublicAccessBlocked, operatorapi.ConditionFalse, "Unknown Error Occurred", err.Error())
+		}
+		return
+	}
+	util.UpdateCondition(cr, defaults.StoragePublicAccessBlocked, operatorapi.ConditionTrue, "Public Access Block Successful", "Public access to the GCS bucket and its contents have been successfully blocked.")
+}
+
 func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 	if len(d.Config.Bucket) == 0 {
 		return false, nil
 	}
 
-	err := d.bucketExists(d.Config.Bucket)
+	attrs, err := d.bucketAttrs(d.Config.Bucket)
 	if err != nil && err == gstorage.ErrBucketNotExist {
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "Bucket does not exist", err.Error())
 		return false, nil
@@ -197,11 +247,62 @@ func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 		return false, err
 	}
 
+	// The bucket has to be set up again until the permissions check passes.
+	if util.PermissionsCheckFailed(cr) {
+		return false, nil
+	}
+
 	util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionTrue, "GCS Bucket Exists", "")
 
+	// Let StorageChanged know that the settings of the managed bucket
+	// drifted and have to be reconciled. If the last attempt to block public
+	// access failed, e.g. because the credentials lack
+	// storage.buckets.update, the failure stays recorded and it is not
+	// retried until the storage configuration changes.
+	if blocksPublicAccess(cr) {
+		cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StoragePublicAccessBlocked)
+		if publicAccessBlocked(attrs) {
+			util.UpdateCondition(cr, defaults.StoragePublicAccessBlocked, operatorapi.ConditionTrue, "Public Access Block Successful", "Public access to the GCS bucket and its contents have been successfully blocked.")
+		} else if cond == nil || cond.Status != operatorapi.ConditionFalse {
+			util.UpdateCondition(cr, defaults.StoragePublicAccessBlocked, operatorapi.ConditionFalse, publicAccessNotBlockedReason, "Public access to the GCS bucket is not blocked")
+		}
+	}
+
 	return true, nil
 }
 
+// isForbidden returns true if err is a GCS API error with the 403 status code.
+func isForbidden(err error) bool {
+	gerr, ok := err.(*gapi.Error)
+	return ok && gerr.Code == http.StatusForbidden
+}
+
+// VerifyPermissions checks that the credentials allow the image registry to
+// write and delete objects in the bucket. It writes a small object under the
+// prefix used by the image registry and removes it.
+func (d *driver) VerifyPermissions() error {
+	gclient, err := d.getGCSClient()
+	if err != nil {
+		return err
+	}
+
+	obj := gclient.Bucket(d.Config.Bucket).Object(util.PermissionsCheckObjectName)
+
+	w := obj.NewWriter(d.Context)
+	if err := w.Close(); isForbidden(err) {
+		return &util.InsufficientPermissionsError{Action: "storage.objects.create", Err: err}
+	} else if err != nil {
+		return err
+	}
+
+	if err := obj.Delete(d.Context); isForbidden(err) {
+		return &util.InsufficientPermissionsError{Action: "storage.objects.delete", Err: err}
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (d *driver) StorageChanged(cr *imageregistryv1.Config) bool {
 	if !reflect.DeepEqual(cr.Status.Storage.GCS, cr.Spec.Storage.GCS) {
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionUnknown, "GCS Configuration Changed", "GCS storage is in an unknown state")
@@ -214,6 +315,13 @@ func (d *driver) StorageChanged(cr *imageregistryv1.Config) bool {
 		return true
 	}
 
+	// The settings of the managed bucket drifted.
+	if blocksPublicAccess(cr) {
+		if cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StoragePublicAccessBlocked); cond != nil && cond.Reason == publicAccessNotBlockedReason {
+			return true
+		}
+	}
+
 	return false
 }
 
@@ -258,7 +366,16 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 				return err
 			}
 		}
-		bucketAttrs := gstorage.BucketAttrs{Location: d.Config.Region}
+		bucketAttrs := gstorage.BucketAttrs{
+			Location: d.Config.Region,
+		}
+		// Block public access to the bucket and its objects by default
+		if !publicAccessBlockDisabled(cr) {
+			bucketAttrs.UniformBucketLevelAccess = gstorage.UniformBucketLevelAccess{
+				Enabled: true,
+			}
+			bucketAttrs.PublicAccessPrevention = gstorage.PublicAccessPreventionEnforced
+		}
 		bucket = gclient.Bucket(d.Config.Bucket)
 
 		labels, err := getUserLabels(d.Listers.Infrastructures)
@@ -287,6 +404,9 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 		cr.Spec.Storage.GCS = d.Config.DeepCopy()
 
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionTrue, "Creation Successful", "GCS bucket was successfully created")
+		if !publicAccessBlockDisabled(cr) {
+			util.UpdateCondition(cr, defaults.StoragePublicAccessBlocked, operatorapi.ConditionTrue, "Public Access Block Successful", "Public access to the GCS bucket and its contents have been successfully blocked.")
+		}
 		if len(bucketAttrs.Labels) > 0 {
 			util.UpdateCondition(cr, defaults.StorageLabeled, operatorapi.ConditionTrue, "Bucket Labeled Successfully",
 				fmt.Sprintf("Successfully added user-defined labels to %s storage bucket", d.Config.Bucket))
@@ -295,6 +415,10 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 
 	// TODO: Wait until the bucket exists
 
+	if !bucketCreated && blocksPublicAccess(cr) {
+		d.blockPublicAccess(cr, bucket)
+	}
+
 	// Set KMS Key ID for encryption on the bucket (if specified)
 	// Data is encrypted by default on GCS: https://cloud.google.com/storage/docs/encryption/
 	if bucketCreated {
@@ -320,19 +444,30 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 				cr.Spec.Storage.GCS = d.Config.DeepCopy()
 			}
 		}
-		return addTagsToStorageBucket(d.Context, cr, d.Listers, d.Config.Bucket, d.Config.Region)
+		if err := addTagsToStorageBucket(d.Context, cr, d.Listers, d.Config.Bucket, d.Config.Region); err != nil {
+			return err
+		}
 	} else {
 		if !reflect.DeepEqual(cr.Status.Storage.GCS, d.Config) {
 			cr.Status.Storage = imageregistryv1.ImageRegistryConfigStorage{
 				GCS: d.Config.DeepCopy(),
 			}
 		}
+
+		// Previous status condition was set to failed because, of tag operation failure,
+		// means bucket creation was successful and hence will only try adding tags.
+		if cond := util.FetchCondition(cr, defaults.StorageTagged); cond.Status != operatorapi.ConditionTrue {
+			if err := addTagsToStorageBucket(d.Context, cr, d.Listers, d.Config.Bucket, d.Config.Region); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Previous status condition was set to failed because, of tag operation failure,
-	// means bucket creation was successful and hence will only try adding tags.
-	if cond := util.FetchCondition(cr, defaults.StorageTagged); cond.Status != operatorapi.ConditionTrue {
-		return addTagsToStorageBucket(d.Context, cr, d.Listers, d.Config.Bucket, d.Config.Region)
+	// Check once that the registry will be able to push into the bucket.
+	// The check object is kept under the prefix used by the registry, so
+	// buckets that are not managed by the operator are checked as well.
+	if err := util.VerifyPermissions(cr, d.VerifyPermissions); err != nil {
+		return err
 	}
 
 	return nil
@@ -349,8 +484,11 @@ func (d *driver) RemoveStorage(cr *imageregistryv1.Config) (bool, error) {
 
 	itr := gclient.Bucket(d.Config.Bucket).Objects(d.Context, nil)
 	klog.V(5).Infof("deleting all objects in bucket %s", d.Config.Bucket)
-	for attr, err := itr.Next(); err == nil || err != iterator.Done; {
-		if err != nil {
+	for {
+		attr, err := itr.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
 			return false, err
 		}
 		klog.V(5).Infof("deleting object %s", attr.Name)
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -14,14 +16,18 @@ import (
 
 	configv1 "github.com/openshift/api/config/v1"
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 
 	cirofake "github.com/openshift/cluster-image-registry-operator/pkg/client/fake"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 )
 
 // tripper is injected on gcs client to simulate api responses.
 type tripper struct {
 	req            int
+	reqBodies      []string
 	responseCodes  []int
 	responseBodies []string
 }
@@ -30,6 +36,13 @@ func (r *tripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	defer func() {
 		r.req++
 	}()
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.reqBodies = append(r.reqBodies, string(body))
+	}
 	return &http.Response{
 		StatusCode: r.responseCodes[r.req],
 		Body:       io.NopCloser(bytes.NewBufferString(r.responseBodies[r.req])),
@@ -120,8 +133,8 @@ func TestStorageManagementState(t *testing.T) {
 					},
 				},
 			},
-			responseCodes:  []int{http.StatusNotFound, http.StatusOK},
-			responseBodies: []string{`{"error":{"code":404}}`, `{}`},
+			responseCodes:  []int{http.StatusNotFound, http.StatusOK, http.StatusOK, http.StatusOK},
+			responseBodies: []string{`{"error":{"code":404}}`, `{}`, `{}`, `{}`},
 		},
 		{
 			name: "unexpected api error (management state unset)",
@@ -159,6 +172,9 @@ func TestStorageManagementState(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			rt := &tripper{}
 			if len(tt.responseCodes) == 0 {
+				// The bucket request, and the permissions check.
+				rt.AddResponse(http.StatusOK, "{}")
+				rt.AddResponse(http.StatusOK, "{}")
 				rt.AddResponse(http.StatusOK, "{}")
 			} else {
 				for i, code := range tt.responseCodes {
@@ -191,3 +207,711 @@ func TestStorageManagementState(t *testing.T) {
 		})
 	}
 }
+
+func TestGetConfig(t *testing.T) {
+	infra := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.GCPPlatformType,
+				GCP: &configv1.GCPPlatformStatus{
+					ProjectID: "project-id",
+					Region:    "us-central1",
+				},
+			},
+		},
+	}
+	cloudCredentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"service_account.json": []byte("cloud-credentials-keyfile"),
+		},
+	}
+
+	for _, tt := range []struct {
+		name            string
+		secrets         []*corev1.Secret
+		expectedKeyfile string
+		err             string
+		invalidConfig   bool
+	}{
+		{
+			name:            "cloud credentials",
+			secrets:         []*corev1.Secret{cloudCredentials},
+			expectedKeyfile: "cloud-credentials-keyfile",
+		},
+		{
+			name: "user provided credentials take precedence",
+			secrets: []*corev1.Secret{
+				cloudCredentials,
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      defaults.ImageRegistryPrivateConfigurationUser,
+						Namespace: defaults.ImageRegistryOperatorNamespace,
+					},
+					Data: map[string][]byte{
+						"REGISTRY_STORAGE_GCS_KEYFILE": []byte("user-keyfile"),
+					},
+				},
+			},
+			expectedKeyfile: "user-keyfile",
+		},
+		{
+			name: "user provided secret without keyfile",
+			secrets: []*corev1.Secret{
+				cloudCredentials,
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      defaults.ImageRegistryPrivateConfigurationUser,
+						Namespace: defaults.ImageRegistryOperatorNamespace,
+					},
+				},
+			},
+			err:           `does not contain required key "REGISTRY_STORAGE_GCS_KEYFILE"`,
+			invalidConfig: true,
+		},
+		{
+			name: "cloud credentials without keyfile",
+			secrets: []*corev1.Secret{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      defaults.CloudCredentialsName,
+						Namespace: defaults.ImageRegistryOperatorNamespace,
+					},
+				},
+			},
+			err: `does not contain required key "service_account.json"`,
+		},
+		{
+			name: "no credentials",
+			err:  "unable to get cluster minted credentials",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			listers := cirofake.NewFixturesBuilder().AddInfraConfig(infra).AddSecrets(tt.secrets...).BuildListers()
+
+			cfg, err := GetConfig(&listers.StorageListers)
+			if len(tt.err) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error to contain %q, got %v", tt.err, err)
+				}
+				var invalidConfigErr *util.InvalidConfigurationError
+				if errors.As(err, &invalidConfigErr) != tt.invalidConfig {
+					t.Errorf("expected invalid configuration error to be %t, got %#+v", tt.invalidConfig, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if cfg.KeyfileData != tt.expectedKeyfile {
+				t.Errorf("expected keyfile %q, got %q", tt.expectedKeyfile, cfg.KeyfileData)
+			}
+			if cfg.ProjectID != "project-id" || cfg.Region != "us-central1" {
+				t.Errorf("expected project and region from the infrastructure, got %q and %q", cfg.ProjectID, cfg.Region)
+			}
+		})
+	}
+}
+
+func TestPublicAccessBlocked(t *testing.T) {
+	accountConfigJSON, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project-id",
+		"private_key_id": "key-id",
+		"client_email":   "service-account-email",
+		"client_id":      "client-id",
+	})
+	if err != nil {
+		t.Fatalf("error marshalling config json: %v", err)
+	}
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.GCPPlatformType,
+				GCP:  &configv1.GCPPlatformStatus{},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"service_account.json": accountConfigJSON,
+		},
+	})
+	listers := builder.BuildListers()
+
+	newConfig := func(managementState string) *imageregistryv1.Config {
+		gcs := &imageregistryv1.ImageRegistryConfigStorageGCS{
+			Bucket: "a-bucket",
+		}
+		return &imageregistryv1.Config{
+			Spec: imageregistryv1.ImageRegistrySpec{
+				Storage: imageregistryv1.ImageRegistryConfigStorage{
+					ManagementState: managementState,
+					GCS:             gcs,
+				},
+			},
+			Status: imageregistryv1.ImageRegistryStatus{
+				Storage: imageregistryv1.ImageRegistryConfigStorage{
+					GCS: gcs.DeepCopy(),
+				},
+			},
+		}
+	}
+
+	t.Run("existing managed bucket is updated", func(t *testing.T) {
+		cr := newConfig(imageregistryv1.StorageManagementStateManaged)
+
+		rt := &tripper{}
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusOK, "{}")
+
+		drv := NewDriver(context.Background(), cr.Spec.Storage.GCS, &listers.StorageListers)
+		drv.httpClient = &http.Client{Transport: rt}
+
+		if err := drv.CreateStorage(cr); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The bucket update is followed by the permissions check object.
+		if len(rt.reqBodies) != 2 {
+			t.Fatalf("expected a bucket update and an object upload request, got %d: %v", len(rt.reqBodies), rt.reqBodies)
+		}
+		var update struct {
+			IamConfiguration struct {
+				UniformBucketLevelAccess struct {
+					Enabled bool `json:"enabled"`
+				} `json:"uniformBucketLevelAccess"`
+				PublicAccessPrevention string `json:"publicAccessPrevention"`
+			} `json:"iamConfiguration"`
+		}
+		if err := json.Unmarshal([]byte(rt.reqBodies[0]), &update); err != nil {
+			t.Fatalf("unable to decode bucket update request: %v", err)
+		}
+		if !update.IamConfiguration.UniformBucketLevelAccess.Enabled {
+			t.Errorf("expected uniform bucket-level access to be enabled, got %s", rt.reqBodies[0])
+		}
+		if update.IamConfiguration.PublicAccessPrevention != "enforced" {
+			t.Errorf("expected public access prevention to be enforced, got %s", rt.reqBodies[0])
+		}
+
+		cond := util.FetchCondition(cr, defaults.StoragePublicAccessBlocked)
+		if cond.Status != operatorapi.ConditionTrue {
+			t.Errorf("expected %s to be true, got %#+v", defaults.StoragePublicAccessBlocked, cond)
+		}
+	})
+
+	for _, tt := range []struct {
+		name            string
+		managementState string
+		annotations     map[string]string
+		condition       *operatorapi.OperatorCondition
+		expectChanged   bool
+	}{
+		{
+			name:            "drifted Managed bucket",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			condition:       &operatorapi.OperatorCondition{Status: operatorapi.ConditionTrue},
+			expectChanged:   true,
+		},
+		{
+			name:            "drifted Unmanaged bucket",
+			managementState: imageregistryv1.StorageManagementStateUnmanaged,
+			condition:       &operatorapi.OperatorCondition{Status: operatorapi.ConditionTrue},
+			expectChanged:   false,
+		},
+		{
+			name:            "Managed bucket created before public access was blocked",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			expectChanged:   true,
+		},
+		{
+			name:            "Managed bucket that cannot be updated",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			condition:       &operatorapi.OperatorCondition{Status: operatorapi.ConditionFalse, Reason: "403"},
+			expectChanged:   false,
+		},
+		{
+			name:            "Managed bucket with public access block disabled",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			annotations:     map[string]string{defaults.BlockPublicAccessAnnotation: "false"},
+			expectChanged:   false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newConfig(tt.managementState)
+			cr.Annotations = tt.annotations
+			util.UpdateCondition(cr, defaults.StorageTagged, operatorapi.ConditionTrue, "", "")
+			if tt.condition != nil {
+				util.UpdateCondition(cr, defaults.StoragePublicAccessBlocked, tt.condition.Status, tt.condition.Reason, "")
+			}
+
+			rt := &tripper{}
+			rt.AddResponse(http.StatusOK, `{"iamConfiguration":{"uniformBucketLevelAccess":{"enabled":false},"publicAccessPrevention":"inherited"}}`)
+
+			drv := NewDriver(context.Background(), cr.Spec.Storage.GCS, &listers.StorageListers)
+			drv.httpClient = &http.Client{Transport: rt}
+
+			exists, err := drv.StorageExists(cr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !exists {
+				t.Fatal("expected the bucket to exist")
+			}
+
+			if changed := drv.StorageChanged(cr); changed != tt.expectChanged {
+				t.Errorf("expected StorageChanged to be %t, got %t", tt.expectChanged, changed)
+			}
+			if tt.condition != nil && tt.condition.Status == operatorapi.ConditionFalse {
+				if cond := util.FetchCondition(cr, defaults.StoragePublicAccessBlocked); cond.Reason != tt.condition.Reason {
+					t.Errorf("expected the failure to stay recorded, got %#+v", cond)
+				}
+			}
+		})
+	}
+
+	t.Run("bucket created with public access block disabled", func(t *testing.T) {
+		cr := &imageregistryv1.Config{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{defaults.BlockPublicAccessAnnotation: "false"},
+			},
+			Spec: imageregistryv1.ImageRegistrySpec{
+				Storage: imageregistryv1.ImageRegistryConfigStorage{
+					GCS: &imageregistryv1.ImageRegistryConfigStorageGCS{
+						Bucket: "a-bucket",
+					},
+				},
+			},
+		}
+
+		rt := &tripper{}
+		rt.AddResponse(http.StatusNotFound, `{"error":{"code":404}}`)
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusOK, "{}")
+
+		drv := NewDriver(context.Background(), cr.Spec.Storage.GCS, &listers.StorageListers)
+		drv.httpClient = &http.Client{Transport: rt}
+
+		if err := drv.CreateStorage(cr); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(rt.reqBodies) == 0 {
+			t.Fatal("expected a bucket create request")
+		}
+		if strings.Contains(rt.reqBodies[0], "iamConfiguration") {
+			t.Errorf("expected the bucket to be created without changing its IAM configuration, got %s", rt.reqBodies[0])
+		}
+		if cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StoragePublicAccessBlocked); cond != nil {
+			t.Errorf("expected no %s condition, got %#+v", defaults.StoragePublicAccessBlocked, cond)
+		}
+	})
+}
+
+func TestCreateStorageLabels(t *testing.T) {
+	accountConfigJSON, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project-id",
+		"private_key_id": "key-id",
+		"client_email":   "service-account-email",
+		"client_id":      "client-id",
+	})
+	if err != nil {
+		t.Fatalf("error marshalling config json: %v", err)
+	}
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "test-infra-abcde",
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.GCPPlatformType,
+				GCP: &configv1.GCPPlatformStatus{
+					ResourceLabels: []configv1.GCPResourceLabel{
+						{Key: "cost-center", Value: "registry"},
+					},
+				},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"service_account.json": accountConfigJSON,
+		},
+	})
+	listers := builder.BuildListers()
+
+	cr := &imageregistryv1.Config{
+		Spec: imageregistryv1.ImageRegistrySpec{
+			Storage: imageregistryv1.ImageRegistryConfigStorage{
+				GCS: &imageregistryv1.ImageRegistryConfigStorageGCS{
+					Bucket: "a-bucket",
+				},
+			},
+		},
+	}
+
+	rt := &tripper{}
+	rt.AddResponse(http.StatusNotFound, `{"error":{"code":404}}`)
+	rt.AddResponse(http.StatusOK, "{}")
+	rt.AddResponse(http.StatusOK, "{}")
+	rt.AddResponse(http.StatusOK, "{}")
+
+	drv := NewDriver(context.Background(), cr.Spec.Storage.GCS, &listers.StorageListers)
+	drv.httpClient = &http.Client{Transport: rt}
+
+	// Tagging is not configured in the infrastructure, errors from the tag
+	// bindings client are not relevant here.
+	_ = drv.CreateStorage(cr)
+
+	// The bucket create request is followed by the permissions check object.
+	if len(rt.reqBodies) != 2 {
+		t.Fatalf("expected a bucket create and an object upload request, got %d: %v", len(rt.reqBodies), rt.reqBodies)
+	}
+	var create struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := json.Unmarshal([]byte(rt.reqBodies[0]), &create); err != nil {
+		t.Fatalf("unable to decode bucket create request: %v", err)
+	}
+	expected := map[string]string{
+		"kubernetes-io-cluster-test-infra-abcde": "owned",
+		"cost-center":                            "registry",
+	}
+	if !reflect.DeepEqual(create.Labels, expected) {
+		t.Errorf("expected bucket labels %v, got %v", expected, create.Labels)
+	}
+
+	cond := util.FetchCondition(cr, defaults.StorageLabeled)
+	if cond.Status != operatorapi.ConditionTrue {
+		t.Errorf("expected %s to be true, got %#+v", defaults.StorageLabeled, cond)
+	}
+}
+
+func TestVerifyPermissions(t *testing.T) {
+	accountConfigJSON, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project-id",
+		"private_key_id": "key-id",
+		"client_email":   "service-account-email",
+		"client_id":      "client-id",
+	})
+	if err != nil {
+		t.Fatalf("error marshalling config json: %v", err)
+	}
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.GCPPlatformType,
+				GCP:  &configv1.GCPPlatformStatus{},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"service_account.json": accountConfigJSON,
+		},
+	})
+	listers := builder.BuildListers()
+
+	forbidden := `{"error":{"code":403,"message":"Forbidden"}}`
+
+	for _, tt := range []struct {
+		name           string
+		responseCodes  []int
+		responseBodies []string
+		action         string
+		err            string
+	}{
+		{
+			name:           "credentials are sufficient",
+			responseCodes:  []int{http.StatusOK, http.StatusNoContent},
+			responseBodies: []string{"{}", ""},
+		},
+		{
+			name:           "object create is forbidden",
+			responseCodes:  []int{http.StatusForbidden},
+			responseBodies: []string{forbidden},
+			action:         "storage.objects.create",
+		},
+		{
+			name:           "object delete is forbidden",
+			responseCodes:  []int{http.StatusOK, http.StatusForbidden},
+			responseBodies: []string{"{}", forbidden},
+			action:         "storage.objects.delete",
+		},
+		{
+			name:           "unexpected api error",
+			responseCodes:  []int{http.StatusBadRequest},
+			responseBodies: []string{`{"error":{"code":400,"message":"Bad Request"}}`},
+			err:            "Bad Request",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &tripper{}
+			for i, code := range tt.responseCodes {
+				rt.AddResponse(code, tt.responseBodies[i])
+			}
+
+			drv := NewDriver(context.Background(), &imageregistryv1.ImageRegistryConfigStorageGCS{
+				Bucket: "a-bucket",
+			}, &listers.StorageListers)
+			drv.httpClient = &http.Client{Transport: rt}
+
+			err := drv.VerifyPermissions()
+
+			var permErr *util.InsufficientPermissionsError
+			isPermErr := errors.As(err, &permErr)
+			switch {
+			case tt.action != "":
+				if !isPermErr {
+					t.Fatalf("expected an insufficient permissions error, got %v", err)
+				}
+				if permErr.Action != tt.action {
+					t.Errorf("expected the missing action to be %q, got %q", tt.action, permErr.Action)
+				}
+			case tt.err != "":
+				if err == nil || isPermErr || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected error to contain %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateStorageVerifiesPermissions(t *testing.T) {
+	accountConfigJSON, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project-id",
+		"private_key_id": "key-id",
+		"client_email":   "service-account-email",
+		"client_id":      "client-id",
+	})
+	if err != nil {
+		t.Fatalf("error marshalling config json: %v", err)
+	}
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.GCPPlatformType,
+				GCP:  &configv1.GCPPlatformStatus{},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"service_account.json": accountConfigJSON,
+		},
+	})
+	listers := builder.BuildListers()
+
+	newConfig := func(managementState string) *imageregistryv1.Config {
+		cr := &imageregistryv1.Config{
+			Spec: imageregistryv1.ImageRegistrySpec{
+				Storage: imageregistryv1.ImageRegistryConfigStorage{
+					ManagementState: managementState,
+					GCS: &imageregistryv1.ImageRegistryConfigStorageGCS{
+						Bucket: "a-bucket",
+					},
+				},
+			},
+		}
+		util.UpdateCondition(cr, defaults.StorageTagged, operatorapi.ConditionTrue, "", "")
+		return cr
+	}
+
+	t.Run("unmanaged bucket", func(t *testing.T) {
+		cr := newConfig(imageregistryv1.StorageManagementStateUnmanaged)
+
+		rt := &tripper{}
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusForbidden, `{"error":{"code":403,"message":"Forbidden"}}`)
+
+		drv := NewDriver(context.Background(), cr.Spec.Storage.GCS, &listers.StorageListers)
+		drv.httpClient = &http.Client{Transport: rt}
+
+		err := drv.CreateStorage(cr)
+		var permErr *util.InsufficientPermissionsError
+		if !errors.As(err, &permErr) {
+			t.Fatalf("expected an insufficient permissions error, got %v", err)
+		}
+		if cond := util.FetchCondition(cr, defaults.StorageExists); cond.Reason != util.InsufficientPermissionsReason {
+			t.Errorf("expected StorageExists reason %s, got %#+v", util.InsufficientPermissionsReason, cond)
+		}
+	})
+
+	t.Run("managed bucket", func(t *testing.T) {
+		cr := newConfig(imageregistryv1.StorageManagementStateManaged)
+
+		rt := &tripper{}
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusOK, "{}")
+		rt.AddResponse(http.StatusForbidden, `{"error":{"code":403,"message":"Forbidden"}}`)
+		rt.AddResponse(http.StatusOK, `{"iamConfiguration":{"uniformBucketLevelAccess":{"enabled":true},"publicAccessPrevention":"enforced"}}`)
+
+		drv := NewDriver(context.Background(), cr.Spec.Storage.GCS, &listers.StorageListers)
+		drv.httpClient = &http.Client{Transport: rt}
+
+		err := drv.CreateStorage(cr)
+		var permErr *util.InsufficientPermissionsError
+		if !errors.As(err, &permErr) {
+			t.Fatalf("expected an insufficient permissions error, got %v", err)
+		}
+		if cond := util.FetchCondition(cr, defaults.StorageExists); cond.Reason != util.InsufficientPermissionsReason {
+			t.Errorf("expected StorageExists reason %s, got %#+v", util.InsufficientPermissionsReason, cond)
+		}
+
+		// The bucket is set up again until the check passes.
+		if exists, err := drv.StorageExists(cr); err != nil || exists {
+			t.Errorf("expected the storage to be reported as missing, got %t, %v", exists, err)
+		}
+	})
+}
+
+func TestRemoveStorage(t *testing.T) {
+	accountConfigJSON, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project-id",
+		"private_key_id": "key-id",
+		"client_email":   "service-account-email",
+		"client_id":      "client-id",
+	})
+	if err != nil {
+		t.Fatalf("error marshalling config json: %v", err)
+	}
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.GCPPlatformType,
+				GCP:  &configv1.GCPPlatformStatus{},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"service_account.json": accountConfigJSON,
+		},
+	})
+	listers := builder.BuildListers()
+
+	for _, tt := range []struct {
+		name            string
+		managementState string
+		responseCodes   []int
+		responseBodies  []string
+		expectRemoved   bool
+	}{
+		{
+			name:            "unmanaged bucket is kept",
+			managementState: imageregistryv1.StorageManagementStateUnmanaged,
+		},
+		{
+			name:            "managed bucket is removed with its objects",
+			managementState: imageregistryv1.StorageManagementStateManaged,
+			responseCodes:   []int{http.StatusOK, http.StatusNoContent, http.StatusNoContent, http.StatusNoContent},
+			responseBodies: []string{
+				`{"items":[{"name":"docker/registry/v2/a","bucket":"a-bucket"},{"name":"docker/registry/v2/b","bucket":"a-bucket"}]}`,
+				"",
+				"",
+				"",
+			},
+			expectRemoved: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						ManagementState: tt.managementState,
+						GCS: &imageregistryv1.ImageRegistryConfigStorageGCS{
+							Bucket: "a-bucket",
+						},
+					},
+				},
+			}
+
+			// The tripper fails on any request it has no response for.
+			rt := &tripper{}
+			for i, code := range tt.responseCodes {
+				rt.AddResponse(code, tt.responseBodies[i])
+			}
+
+			drv := NewDriver(context.Background(), cr.Spec.Storage.GCS.DeepCopy(), &listers.StorageListers)
+			drv.httpClient = &http.Client{Transport: rt}
+
+			removed, err := drv.RemoveStorage(cr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if removed != tt.expectRemoved {
+				t.Errorf("expected removed to be %t, got %t", tt.expectRemoved, removed)
+			}
+			if rt.req != len(tt.responseCodes) {
+				t.Errorf("expected %d requests, got %d", len(tt.responseCodes), rt.req)
+			}
+
+			expectedBucket := "a-bucket"
+			if tt.expectRemoved {
+				expectedBucket = ""
+			}
+			if cr.Spec.Storage.GCS.Bucket != expectedBucket {
+				t.Errorf("expected spec bucket to be %q, got %q", expectedBucket, cr.Spec.Storage.GCS.Bucket)
+			}
+		})
+	}
+}
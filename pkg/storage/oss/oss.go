@@ -403,6 +403,9 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 						util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, oerr.Code, oerr.Error())
 						return err
 					}
+				} else {
+					util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionUnknown, "Unknown Error Occurred", err.Error())
+					return err
 				}
 			}
 			if cr.Spec.Storage.ManagementState == "" {
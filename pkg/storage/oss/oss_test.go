@@ -16,10 +16,12 @@ import (
 	"github.com/google/go-cmp/cmp"
 	configv1 "github.com/openshift/api/config/v1"
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	operatorapi "github.com/openshift/api/operator/v1"
 
 	cirofake "github.com/openshift/cluster-image-registry-operator/pkg/client/fake"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
 	"github.com/openshift/cluster-image-registry-operator/pkg/envvar"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -290,6 +292,7 @@ func TestStorageManagementState(t *testing.T) {
 			Name: "cluster",
 		},
 		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "test-infra",
 			PlatformStatus: &configv1.PlatformStatus{
 				Type: configv1.AlibabaCloudPlatformType,
 				AlibabaCloud: &configv1.AlibabaCloudPlatformStatus{
@@ -432,6 +435,63 @@ func TestStorageManagementState(t *testing.T) {
 	}
 }
 
+type errorTripper struct {
+	err error
+}
+
+func (r *errorTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, r.err
+}
+
+func TestCreateStorageConnectionFailure(t *testing.T) {
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "test-infra",
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AlibabaCloudPlatformType,
+				AlibabaCloud: &configv1.AlibabaCloudPlatformStatus{
+					Region: "us-west-1",
+				},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			imageRegistrySecretDataKey: generateInitCredentialForSec(),
+		},
+	})
+	listers := builder.BuildListers()
+
+	cr := &imageregistryv1.Config{
+		Spec: imageregistryv1.ImageRegistrySpec{
+			Storage: imageregistryv1.ImageRegistryConfigStorage{
+				OSS: &imageregistryv1.ImageRegistryConfigStorageAlibabaOSS{},
+			},
+		},
+	}
+
+	drv := NewDriver(context.Background(), cr.Spec.Storage.OSS, &listers.StorageListers)
+	drv.roundTripper = &errorTripper{err: fmt.Errorf("connection refused")}
+
+	if err := drv.CreateStorage(cr); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	if cr.Spec.Storage.ManagementState != "" {
+		t.Errorf("expected the management state to stay empty, got %q", cr.Spec.Storage.ManagementState)
+	}
+	if cond := util.FetchCondition(cr, defaults.StorageExists); cond.Status != operatorapi.ConditionUnknown {
+		t.Errorf("expected the StorageExists condition to be Unknown, got %#+v", cond)
+	}
+}
+
 func TestUserProvidedTags(t *testing.T) {
 	for _, tt := range []struct {
 		name          string
@@ -4,17 +4,24 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	coreset "k8s.io/client-go/kubernetes/typed/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
 
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
 	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 
 	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
@@ -25,15 +32,53 @@ import (
 const (
 	rootDirectory      = "/registry"
 	PVCOwnerAnnotation = "imageregistry.openshift.io"
+
+	defaultStorageClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+
+	// readWriteManyNotSupportedReason is the StorageReadWriteOnce reason
+	// when the volume for the claim could not be provisioned with the
+	// ReadWriteMany access mode.
+	readWriteManyNotSupportedReason = "ReadWriteManyNotSupported"
+
+	// readWriteOnceClaimCreatedReason is the StorageReadWriteOnce reason once
+	// the claim is created again with the ReadWriteOnce access mode.
+	readWriteOnceClaimCreatedReason = "ReadWriteOnceClaimCreated"
 )
 
+// accessModeRejections are fragments of provisioning errors that mean that
+// the provisioner doesn't support the requested access mode, e.g.
+// "Volume capabilities MULTI_NODE_MULTI_WRITER not supported" from CSI
+// drivers or "invalid AccessModes [ReadWriteMany]" from in-tree plugins.
+// Other errors, e.g. throttling or exhausted capacity, are retried by the
+// provisioner and don't say anything about the access mode.
+var accessModeRejections = []string{
+	"volume capabilit",
+	"accessmode",
+	"access mode",
+	"multi_node",
+}
+
+// isAccessModeRejection returns true if message is a provisioning error
+// caused by an unsupported access mode.
+func isAccessModeRejection(message string) bool {
+	message = strings.ToLower(message)
+	for _, fragment := range accessModeRejections {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
 type driver struct {
-	Namespace string
-	Config    *imageregistryv1.ImageRegistryConfigStoragePVC
-	Client    coreset.CoreV1Interface
+	Namespace      string
+	Config         *imageregistryv1.ImageRegistryConfigStoragePVC
+	Client         coreset.CoreV1Interface
+	StorageClasses storagelisters.StorageClassLister
 }
 
-func NewDriver(c *imageregistryv1.ImageRegistryConfigStoragePVC, kubeconfig *rest.Config) (*driver, error) {
+func NewDriver(c *imageregistryv1.ImageRegistryConfigStoragePVC, kubeconfig *rest.Config, storageClasses storagelisters.StorageClassLister) (*driver, error) {
 	namespace, err := regopclient.GetWatchNamespace()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get watch namespace: %s", err)
@@ -45,9 +90,10 @@ func NewDriver(c *imageregistryv1.ImageRegistryConfigStoragePVC, kubeconfig *res
 	}
 
 	return &driver{
-		Namespace: namespace,
-		Config:    c,
-		Client:    client,
+		Namespace:      namespace,
+		Config:         c,
+		Client:         client,
+		StorageClasses: storageClasses,
 	}, nil
 }
 
@@ -91,10 +137,28 @@ func (d *driver) Secrets() (map[string]string, error) {
 
 func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 	if len(d.Config.Claim) != 0 {
-		_, err := d.Client.PersistentVolumeClaims(d.Namespace).Get(
+		claim, err := d.Client.PersistentVolumeClaims(d.Namespace).Get(
 			context.TODO(), d.Config.Claim, metav1.GetOptions{},
 		)
 		if err == nil {
+			if replace, err := d.checkReadWriteManyProvisioning(cr, claim); err != nil {
+				util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionUnknown, fmt.Sprintf("Unknown error occurred checking for volume claim %s", d.Config.Claim), err.Error())
+				return false, err
+			} else if replace {
+				util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "PVC Cannot Be Provisioned", fmt.Sprintf("The claim %s has to be created again with %s", d.Config.Claim, corev1.ReadWriteOnce))
+				return false, nil
+			}
+			// The image registry configuration may have been changed after
+			// the claim was checked, e.g. the number of replicas was
+			// increased for a ReadWriteOnce claim.
+			if err := d.checkPVC(cr, claim); err != nil {
+				util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "PVC Issues Found", err.Error())
+				return false, err
+			}
+			if err := d.checkStorageClass(claim); err != nil {
+				util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "PVC Issues Found", err.Error())
+				return false, err
+			}
 			util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionTrue, "PVC Exists", "")
 			return true, nil
 		}
@@ -144,20 +208,139 @@ func (d *driver) checkPVC(cr *imageregistryv1.Config, claim *corev1.PersistentVo
 
 	if rwoModeEnabled {
 		if cr.Spec.Replicas > 1 {
-			return fmt.Errorf("cannot use %s access mode with more than one replica of the image registry", corev1.ReadWriteOnce)
+			return &util.InvalidConfigurationError{
+				Err: fmt.Errorf("cannot use %s access mode with more than one replica of the image registry", corev1.ReadWriteOnce),
+			}
 		}
 
 		if cr.Spec.RolloutStrategy != string(appsv1.RecreateDeploymentStrategyType) {
-			return fmt.Errorf("cannot use %s access mode with %s rollout strategy", corev1.ReadWriteOnce, cr.Spec.RolloutStrategy)
+			return &util.InvalidConfigurationError{
+				Err: fmt.Errorf("cannot use %s access mode with %s rollout strategy", corev1.ReadWriteOnce, cr.Spec.RolloutStrategy),
+			}
 		}
 
 		return nil
 	}
 
-	return fmt.Errorf("PVC %s does not contain the necessary access modes: %s or %s", d.Config.Claim, corev1.ReadWriteMany, corev1.ReadWriteOnce)
+	return &util.InvalidConfigurationError{
+		Err: fmt.Errorf("PVC %s does not contain the necessary access modes: %s or %s", d.Config.Claim, corev1.ReadWriteMany, corev1.ReadWriteOnce),
+	}
+}
+
+// isDefaultStorageClass returns true if class is marked as the default
+// storage class of the cluster.
+func isDefaultStorageClass(class *storagev1.StorageClass) bool {
+	return class.Annotations[defaultStorageClassAnnotation] == "true" ||
+		class.Annotations[betaDefaultStorageClassAnnotation] == "true"
+}
+
+// defaultStorageClass returns the storage class that is used for claims
+// without a storage class or nil if there is none. If several classes are
+// marked as the default, the newest one is used, as the DefaultStorageClass
+// admission plugin does.
+func (d *driver) defaultStorageClass() (*storagev1.StorageClass, error) {
+	classes, err := d.StorageClasses.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("unable to list storage classes: %w", err)
+	}
+	var defaultClass *storagev1.StorageClass
+	for _, class := range classes {
+		if !isDefaultStorageClass(class) {
+			continue
+		}
+		if defaultClass == nil ||
+			class.CreationTimestamp.After(defaultClass.CreationTimestamp.Time) ||
+			(class.CreationTimestamp.Equal(&defaultClass.CreationTimestamp) && class.Name < defaultClass.Name) {
+			defaultClass = class
+		}
+	}
+	return defaultClass, nil
+}
+
+// hasAccessMode returns true if claim requests mode.
+func hasAccessMode(claim *corev1.PersistentVolumeClaim, mode corev1.PersistentVolumeAccessMode) bool {
+	for _, claimMode := range claim.Spec.AccessModes {
+		if claimMode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// usesReadWriteOnce returns true if the claim that the operator creates
+// should use the ReadWriteOnce access mode because a ReadWriteMany volume
+// could not be provisioned for it before.
+func (d *driver) usesReadWriteOnce(cr *imageregistryv1.Config) bool {
+	if d.Config.Claim != "" && d.Config.Claim != defaults.PVCImageRegistryName {
+		return false
+	}
+	cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageReadWriteOnce)
+	return cond != nil && cond.Status == operatorapi.ConditionTrue
+}
+
+// provisioningFailure returns the message of the event that reports that the
+// volume for claim cannot be provisioned with the requested access mode, or
+// an empty string if there is no such event.
+func (d *driver) provisioningFailure(claim *corev1.PersistentVolumeClaim) (string, error) {
+	events, err := d.Client.Events(d.Namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "PersistentVolumeClaim",
+			"involvedObject.name": claim.Name,
+			"reason":              "ProvisioningFailed",
+		}.AsSelector().String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to get events for the claim %s: %w", claim.Name, err)
+	}
+	for _, event := range events.Items {
+		if event.InvolvedObject.UID == claim.UID && event.Reason == "ProvisioningFailed" && isAccessModeRejection(event.Message) {
+			return event.Message, nil
+		}
+	}
+	return "", nil
+}
+
+// checkReadWriteManyProvisioning checks whether the ReadWriteMany claim that
+// the operator created can be provisioned. If the provisioner rejected it,
+// the decision to use ReadWriteOnce is recorded in the StorageReadWriteOnce
+// condition and true is returned: the claim has to be created again.
+func (d *driver) checkReadWriteManyProvisioning(cr *imageregistryv1.Config, claim *corev1.PersistentVolumeClaim) (bool, error) {
+	if !pvcIsCreatedByOperator(claim) ||
+		claim.Status.Phase != corev1.ClaimPending ||
+		claim.Spec.VolumeName != "" ||
+		!hasAccessMode(claim, corev1.ReadWriteMany) {
+		return false, nil
+	}
+
+	failure, err := d.provisioningFailure(claim)
+	if err != nil || failure == "" {
+		return false, err
+	}
+
+	util.UpdateCondition(cr, defaults.StorageReadWriteOnce, operatorapi.ConditionTrue, readWriteManyNotSupportedReason,
+		fmt.Sprintf("A %s volume cannot be provisioned for the claim %s, it is created again with %s: %s", corev1.ReadWriteMany, claim.Name, corev1.ReadWriteOnce, failure))
+	return true, nil
+}
+
+// checkStorageClass verifies that a pending claim that relies on the default
+// storage class can be provisioned.
+func (d *driver) checkStorageClass(claim *corev1.PersistentVolumeClaim) error {
+	if claim.Status.Phase != corev1.ClaimPending || claim.Spec.StorageClassName != nil {
+		return nil
+	}
+	class, err := d.defaultStorageClass()
+	if err != nil {
+		return err
+	}
+	// The default storage class may be created later, the StorageClass
+	// informer triggers a new sync when it happens.
+	if class == nil {
+		return fmt.Errorf("PVC %s cannot be provisioned: the cluster does not have a default storage class", claim.Name)
+	}
+	return nil
 }
 
-func (d *driver) createPVC(cr *imageregistryv1.Config) (*corev1.PersistentVolumeClaim, error) {
+func (d *driver) createPVC(cr *imageregistryv1.Config, accessMode corev1.PersistentVolumeAccessMode) (*corev1.PersistentVolumeClaim, error) {
 	claim := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      d.Config.Claim,
@@ -168,7 +351,7 @@ func (d *driver) createPVC(cr *imageregistryv1.Config) (*corev1.PersistentVolume
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
 			AccessModes: []corev1.PersistentVolumeAccessMode{
-				corev1.ReadWriteMany,
+				accessMode,
 			},
 			Resources: corev1.ResourceRequirements{
 				Requests: corev1.ResourceList{
@@ -190,7 +373,7 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 	)
 
 	managementState := imageregistryv1.StorageManagementStateUnmanaged
-	if len(d.Config.Claim) == 0 {
+	if len(d.Config.Claim) == 0 || d.usesReadWriteOnce(cr) {
 		d.Config.Claim = defaults.PVCImageRegistryName
 
 		// If there is no name and there is no PVC, then we will create a PVC.
@@ -206,16 +389,41 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 				util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "PVC Already Exists", err.Error())
 				return err
 			}
+			if d.usesReadWriteOnce(cr) && hasAccessMode(claim, corev1.ReadWriteMany) && claim.Spec.VolumeName == "" {
+				return d.removeUnprovisionedPVC(cr, claim)
+			}
 		} else if errors.IsNotFound(err) {
-			claim, err = d.createPVC(cr)
+			// The ReadWriteOnce claim was removed by the user, which
+			// allows to try ReadWriteMany again.
+			if cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageReadWriteOnce); cond != nil && cond.Reason == readWriteOnceClaimCreatedReason {
+				v1helpers.RemoveOperatorCondition(&cr.Status.Conditions, defaults.StorageReadWriteOnce)
+			}
+
+			accessMode := corev1.ReadWriteMany
+			if d.usesReadWriteOnce(cr) {
+				accessMode = corev1.ReadWriteOnce
+			}
+			claim, err = d.createPVC(cr, accessMode)
 			if err != nil {
 				util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "Creation Failed", err.Error())
 				return err
 			}
+			if accessMode == corev1.ReadWriteOnce {
+				util.UpdateCondition(cr, defaults.StorageReadWriteOnce, operatorapi.ConditionTrue, readWriteOnceClaimCreatedReason,
+					fmt.Sprintf("A %s volume cannot be provisioned, the claim %s uses %s; remove the claim to try %s again", corev1.ReadWriteMany, claim.Name, corev1.ReadWriteOnce, corev1.ReadWriteMany))
+			}
 			util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionTrue, "PVC Created", "")
 		} else {
 			return err
 		}
+
+		// A ReadWriteOnce volume cannot be mounted by pods on different
+		// nodes, so the registry pod has to be replaced rather than rolled.
+		// The number of replicas is left to the user, checkPVC reports
+		// more than one replica as an invalid configuration.
+		if !hasAccessMode(claim, corev1.ReadWriteMany) && hasAccessMode(claim, corev1.ReadWriteOnce) && cr.Spec.Replicas <= 1 {
+			cr.Spec.RolloutStrategy = string(appsv1.RecreateDeploymentStrategyType)
+		}
 	} else {
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionTrue, "PVC Exists", "")
 	}
@@ -237,6 +445,27 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 	return nil
 }
 
+// removeUnprovisionedPVC deletes the claim created by the operator that could
+// not be provisioned with ReadWriteMany, so that it can be created again with
+// ReadWriteOnce. The claim was never bound, so it doesn't hold any data.
+func (d *driver) removeUnprovisionedPVC(cr *imageregistryv1.Config, claim *corev1.PersistentVolumeClaim) error {
+	if claim.DeletionTimestamp == nil {
+		klog.Infof("removing the claim %s to create it again with %s", claim.Name, corev1.ReadWriteOnce)
+		err := d.Client.PersistentVolumeClaims(d.Namespace).Delete(
+			context.TODO(), claim.Name, metav1.DeleteOptions{
+				Preconditions: &metav1.Preconditions{UID: &claim.UID},
+			},
+		)
+		if err != nil && !errors.IsNotFound(err) {
+			util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "PVC Cannot Be Provisioned", err.Error())
+			return err
+		}
+	}
+	err := fmt.Errorf("waiting for the claim %s to be removed to create it again with %s", claim.Name, corev1.ReadWriteOnce)
+	util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "PVC Cannot Be Provisioned", err.Error())
+	return err
+}
+
 func (d *driver) RemoveStorage(cr *imageregistryv1.Config) (retriable bool, err error) {
 	if cr.Spec.Storage.ManagementState != imageregistryv1.StorageManagementStateManaged ||
 		len(d.Config.Claim) == 0 {
@@ -250,6 +479,9 @@ func (d *driver) RemoveStorage(cr *imageregistryv1.Config) (retriable bool, err
 		return false, err
 	}
 
+	// The next claim is created with ReadWriteMany again.
+	v1helpers.RemoveOperatorCondition(&cr.Status.Conditions, defaults.StorageReadWriteOnce)
+
 	return false, nil
 }
 
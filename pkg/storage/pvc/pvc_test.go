@@ -1,17 +1,27 @@
 package pvc
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
 
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 )
 
 func TestStorageManagementState(t *testing.T) {
@@ -192,9 +202,10 @@ func TestStorageManagementState(t *testing.T) {
 			}
 
 			drv := &driver{
-				Namespace: "openshift-image-registry",
-				Config:    tt.config.Spec.Storage.PVC,
-				Client:    cliset.CoreV1(),
+				Namespace:      "openshift-image-registry",
+				Config:         tt.config.Spec.Storage.PVC,
+				Client:         cliset.CoreV1(),
+				StorageClasses: newStorageClassLister(),
 			}
 
 			if err := drv.CreateStorage(tt.config); err != nil {
@@ -219,3 +230,506 @@ func TestStorageManagementState(t *testing.T) {
 		})
 	}
 }
+
+func newStorageClassLister(classes ...*storagev1.StorageClass) storagelisters.StorageClassLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, class := range classes {
+		if err := indexer.Add(class); err != nil {
+			panic(err)
+		}
+	}
+	return storagelisters.NewStorageClassLister(indexer)
+}
+
+func TestDefaultStorageClass(t *testing.T) {
+	older := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(older.Add(time.Hour))
+	for _, tt := range []struct {
+		name     string
+		classes  []*storagev1.StorageClass
+		expected string
+	}{
+		{
+			name: "no default storage class",
+			classes: []*storagev1.StorageClass{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "standard",
+						Annotations: map[string]string{
+							"storageclass.kubernetes.io/is-default-class": "false",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "default storage class",
+			classes: []*storagev1.StorageClass{
+				{ObjectMeta: metav1.ObjectMeta{Name: "cephfs"}},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "standard",
+						Annotations: map[string]string{
+							"storageclass.kubernetes.io/is-default-class": "true",
+						},
+					},
+				},
+			},
+			expected: "standard",
+		},
+		{
+			name: "beta annotation",
+			classes: []*storagev1.StorageClass{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "standard",
+						Annotations: map[string]string{
+							"storageclass.beta.kubernetes.io/is-default-class": "true",
+						},
+					},
+				},
+			},
+			expected: "standard",
+		},
+		{
+			name: "several default storage classes",
+			classes: []*storagev1.StorageClass{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "a-newer",
+						CreationTimestamp: newer,
+						Annotations: map[string]string{
+							"storageclass.kubernetes.io/is-default-class": "true",
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "b-older",
+						CreationTimestamp: older,
+						Annotations: map[string]string{
+							"storageclass.kubernetes.io/is-default-class": "true",
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "c-newer",
+						CreationTimestamp: newer,
+						Annotations: map[string]string{
+							"storageclass.beta.kubernetes.io/is-default-class": "true",
+						},
+					},
+				},
+			},
+			expected: "a-newer",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &driver{
+				StorageClasses: newStorageClassLister(tt.classes...),
+			}
+
+			class, err := drv.defaultStorageClass()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			name := ""
+			if class != nil {
+				name = class.Name
+			}
+			if name != tt.expected {
+				t.Errorf("expected default storage class %q, got %q", tt.expected, name)
+			}
+		})
+	}
+}
+
+func TestCreateStorageAccessMode(t *testing.T) {
+	for _, tt := range []struct {
+		name               string
+		replicas           int32
+		failureMessage     string
+		expectedAccessMode corev1.PersistentVolumeAccessMode
+		expectedStrategy   string
+		expectedErr        bool
+	}{
+		{
+			name:               "volume is provisioned",
+			replicas:           2,
+			expectedAccessMode: corev1.ReadWriteMany,
+			expectedStrategy:   string(appsv1.RollingUpdateDeploymentStrategyType),
+		},
+		{
+			name:               "provisioning is throttled",
+			replicas:           2,
+			failureMessage:     "failed to provision volume with StorageClass \"standard-csi\": rpc error: code = ResourceExhausted desc = Request limit exceeded",
+			expectedAccessMode: corev1.ReadWriteMany,
+			expectedStrategy:   string(appsv1.RollingUpdateDeploymentStrategyType),
+		},
+		{
+			name:               "shared volumes are not supported",
+			replicas:           1,
+			failureMessage:     "failed to provision volume with StorageClass \"gp3-csi\": rpc error: code = InvalidArgument desc = Volume capabilities MULTI_NODE_MULTI_WRITER not supported. Only AccessModes[ReadWriteOnce] supported.",
+			expectedAccessMode: corev1.ReadWriteOnce,
+			expectedStrategy:   string(appsv1.RecreateDeploymentStrategyType),
+		},
+		{
+			name:               "shared volumes are not supported with several replicas",
+			replicas:           2,
+			failureMessage:     "invalid AccessModes [ReadWriteMany]: only AccessModes [ReadWriteOnce] are supported",
+			expectedAccessMode: corev1.ReadWriteOnce,
+			expectedStrategy:   string(appsv1.RollingUpdateDeploymentStrategyType),
+			expectedErr:        true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			const namespace = "openshift-image-registry"
+			cliset := fake.NewSimpleClientset()
+
+			cr := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Replicas:        tt.replicas,
+					RolloutStrategy: string(appsv1.RollingUpdateDeploymentStrategyType),
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						PVC: &imageregistryv1.ImageRegistryConfigStoragePVC{},
+					},
+				},
+			}
+
+			drv := &driver{
+				Namespace: namespace,
+				Config:    cr.Spec.Storage.PVC,
+				Client:    cliset.CoreV1(),
+				StorageClasses: newStorageClassLister(&storagev1.StorageClass{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "standard-csi",
+						Annotations: map[string]string{
+							"storageclass.kubernetes.io/is-default-class": "true",
+						},
+					},
+				}),
+			}
+
+			if err := drv.CreateStorage(cr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			claim, err := cliset.CoreV1().PersistentVolumeClaims(namespace).Get(
+				context.Background(), defaults.PVCImageRegistryName, metav1.GetOptions{},
+			)
+			if err != nil {
+				t.Fatalf("unable to get the claim: %v", err)
+			}
+			if len(claim.Spec.AccessModes) != 1 || claim.Spec.AccessModes[0] != corev1.ReadWriteMany {
+				t.Fatalf("expected access modes [%s], got %v", corev1.ReadWriteMany, claim.Spec.AccessModes)
+			}
+
+			if tt.failureMessage != "" {
+				claim.UID = "claim-uid"
+				claim.Status.Phase = corev1.ClaimPending
+				if _, err := cliset.CoreV1().PersistentVolumeClaims(namespace).Update(context.Background(), claim, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				_, err := cliset.CoreV1().Events(namespace).Create(context.Background(), &corev1.Event{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: namespace,
+						Name:      "image-registry-storage.provisioning-failed",
+					},
+					InvolvedObject: corev1.ObjectReference{
+						Kind:      "PersistentVolumeClaim",
+						Namespace: namespace,
+						Name:      claim.Name,
+						UID:       claim.UID,
+					},
+					Reason:  "ProvisioningFailed",
+					Message: tt.failureMessage,
+				}, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				exists, err := drv.StorageExists(cr)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if tt.expectedAccessMode == corev1.ReadWriteMany {
+					// The provisioner retries errors that are not
+					// caused by the access mode.
+					if cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageReadWriteOnce); cond != nil {
+						t.Errorf("unexpected %s condition: %#v", defaults.StorageReadWriteOnce, cond)
+					}
+					return
+				}
+				if exists {
+					t.Fatal("expected the claim to be reported as missing")
+				}
+				cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageReadWriteOnce)
+				if cond == nil || cond.Status != operatorapi.ConditionTrue || cond.Reason != readWriteManyNotSupportedReason {
+					t.Fatalf("expected the %s condition to be True, got %#v", defaults.StorageReadWriteOnce, cond)
+				}
+
+				// The claim that cannot be provisioned is removed first and
+				// then created again.
+				if err := drv.CreateStorage(cr); err == nil {
+					t.Fatal("expected an error while the claim is removed")
+				}
+				if err := drv.CreateStorage(cr); err != nil {
+					var invalidConfigErr *util.InvalidConfigurationError
+					if !tt.expectedErr || !errors.As(err, &invalidConfigErr) {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				} else if tt.expectedErr {
+					t.Fatal("expected an invalid configuration error")
+				}
+
+				claim, err = cliset.CoreV1().PersistentVolumeClaims(namespace).Get(
+					context.Background(), defaults.PVCImageRegistryName, metav1.GetOptions{},
+				)
+				if err != nil {
+					t.Fatalf("unable to get the claim: %v", err)
+				}
+				cond = v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageReadWriteOnce)
+				if cond == nil || cond.Status != operatorapi.ConditionTrue || cond.Reason != readWriteOnceClaimCreatedReason {
+					t.Errorf("expected the %s condition to report the new claim, got %#v", defaults.StorageReadWriteOnce, cond)
+				}
+			}
+
+			if len(claim.Spec.AccessModes) != 1 || claim.Spec.AccessModes[0] != tt.expectedAccessMode {
+				t.Errorf("expected access modes [%s], got %v", tt.expectedAccessMode, claim.Spec.AccessModes)
+			}
+			if cr.Spec.Replicas != tt.replicas {
+				t.Errorf("expected the number of replicas to stay %d, got %d", tt.replicas, cr.Spec.Replicas)
+			}
+			if cr.Spec.RolloutStrategy != tt.expectedStrategy {
+				t.Errorf("expected rollout strategy %q, got %q", tt.expectedStrategy, cr.Spec.RolloutStrategy)
+			}
+		})
+	}
+}
+
+func TestReadWriteOnceReset(t *testing.T) {
+	for _, tt := range []struct {
+		name               string
+		reason             string
+		expectedAccessMode corev1.PersistentVolumeAccessMode
+		expectedCondition  bool
+	}{
+		{
+			name:               "unprovisioned claim was removed by the operator",
+			reason:             readWriteManyNotSupportedReason,
+			expectedAccessMode: corev1.ReadWriteOnce,
+			expectedCondition:  true,
+		},
+		{
+			name:               "ReadWriteOnce claim was removed by the user",
+			reason:             readWriteOnceClaimCreatedReason,
+			expectedAccessMode: corev1.ReadWriteMany,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			const namespace = "openshift-image-registry"
+			cliset := fake.NewSimpleClientset()
+
+			cr := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Replicas: 1,
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						ManagementState: imageregistryv1.StorageManagementStateManaged,
+						PVC: &imageregistryv1.ImageRegistryConfigStoragePVC{
+							Claim: defaults.PVCImageRegistryName,
+						},
+					},
+				},
+			}
+			util.UpdateCondition(cr, defaults.StorageReadWriteOnce, operatorapi.ConditionTrue, tt.reason, "")
+
+			drv := &driver{
+				Namespace:      namespace,
+				Config:         cr.Spec.Storage.PVC,
+				Client:         cliset.CoreV1(),
+				StorageClasses: newStorageClassLister(),
+			}
+
+			if err := drv.CreateStorage(cr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			claim, err := cliset.CoreV1().PersistentVolumeClaims(namespace).Get(
+				context.Background(), defaults.PVCImageRegistryName, metav1.GetOptions{},
+			)
+			if err != nil {
+				t.Fatalf("unable to get the claim: %v", err)
+			}
+			if len(claim.Spec.AccessModes) != 1 || claim.Spec.AccessModes[0] != tt.expectedAccessMode {
+				t.Errorf("expected access modes [%s], got %v", tt.expectedAccessMode, claim.Spec.AccessModes)
+			}
+			cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageReadWriteOnce)
+			if (cond != nil) != tt.expectedCondition {
+				t.Errorf("expected the %s condition to be present: %t, got %#v", defaults.StorageReadWriteOnce, tt.expectedCondition, cond)
+			}
+
+			// Removing the storage allows to try ReadWriteMany again.
+			if _, err := drv.RemoveStorage(cr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageReadWriteOnce); cond != nil {
+				t.Errorf("expected the %s condition to be removed with the storage, got %#v", defaults.StorageReadWriteOnce, cond)
+			}
+		})
+	}
+}
+
+func TestStorageExistsReadWriteOnceReplicas(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		replicas       int32
+		expectedExists bool
+		expectedErr    bool
+	}{
+		{
+			name:           "single replica",
+			replicas:       1,
+			expectedExists: true,
+		},
+		{
+			name:        "scaled up",
+			replicas:    2,
+			expectedErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cliset := fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "openshift-image-registry",
+					Name:      "user-provided-pvc",
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{
+						corev1.ReadWriteOnce,
+					},
+				},
+			})
+
+			cr := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Replicas:        tt.replicas,
+					RolloutStrategy: string(appsv1.RecreateDeploymentStrategyType),
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						PVC: &imageregistryv1.ImageRegistryConfigStoragePVC{
+							Claim: "user-provided-pvc",
+						},
+					},
+				},
+			}
+
+			drv := &driver{
+				Namespace:      "openshift-image-registry",
+				Config:         cr.Spec.Storage.PVC,
+				Client:         cliset.CoreV1(),
+				StorageClasses: newStorageClassLister(),
+			}
+
+			exists, err := drv.StorageExists(cr)
+			var invalidConfigErr *util.InvalidConfigurationError
+			if tt.expectedErr != errors.As(err, &invalidConfigErr) {
+				t.Errorf("expected invalid configuration error to be %t, got %v", tt.expectedErr, err)
+			}
+			if exists != tt.expectedExists {
+				t.Errorf("expected exists to be %t, got %t", tt.expectedExists, exists)
+			}
+		})
+	}
+}
+
+func TestStorageExistsDefaultStorageClass(t *testing.T) {
+	emptyClass := ""
+	for _, tt := range []struct {
+		name        string
+		phase       corev1.PersistentVolumeClaimPhase
+		className   *string
+		classes     []*storagev1.StorageClass
+		expectedErr bool
+	}{
+		{
+			name:  "bound claim",
+			phase: corev1.ClaimBound,
+		},
+		{
+			name:        "pending claim without default storage class",
+			phase:       corev1.ClaimPending,
+			expectedErr: true,
+		},
+		{
+			name:      "pending claim for a static volume",
+			phase:     corev1.ClaimPending,
+			className: &emptyClass,
+		},
+		{
+			name:  "pending claim with default storage class",
+			phase: corev1.ClaimPending,
+			classes: []*storagev1.StorageClass{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "ovirt-csi-sc",
+						Annotations: map[string]string{
+							"storageclass.kubernetes.io/is-default-class": "true",
+						},
+					},
+					Provisioner: "csi.ovirt.org",
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cliset := fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "openshift-image-registry",
+					Name:      defaults.PVCImageRegistryName,
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{
+						corev1.ReadWriteOnce,
+					},
+					StorageClassName: tt.className,
+				},
+				Status: corev1.PersistentVolumeClaimStatus{
+					Phase: tt.phase,
+				},
+			})
+
+			cr := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Replicas:        1,
+					RolloutStrategy: string(appsv1.RecreateDeploymentStrategyType),
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						PVC: &imageregistryv1.ImageRegistryConfigStoragePVC{
+							Claim: defaults.PVCImageRegistryName,
+						},
+					},
+				},
+			}
+
+			drv := &driver{
+				Namespace:      "openshift-image-registry",
+				Config:         cr.Spec.Storage.PVC,
+				Client:         cliset.CoreV1(),
+				StorageClasses: newStorageClassLister(tt.classes...),
+			}
+
+			exists, err := drv.StorageExists(cr)
+			if tt.expectedErr != (err != nil) {
+				t.Errorf("expected error to be %t, got %v", tt.expectedErr, err)
+			}
+			// A missing default storage class may be fixed without
+			// changing the operator configuration, the sync is retried.
+			var invalidConfigErr *util.InvalidConfigurationError
+			if errors.As(err, &invalidConfigErr) {
+				t.Errorf("expected a retriable error, got %v", err)
+			}
+			if exists == tt.expectedErr {
+				t.Errorf("expected exists to be %t, got %t", !tt.expectedErr, exists)
+			}
+		})
+	}
+}
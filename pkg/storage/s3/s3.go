@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -42,6 +43,16 @@ import (
 const (
 	imageRegistrySecretMountpoint = "/var/run/secrets/cloud"
 	imageRegistrySecretDataKey    = "credentials"
+
+	// defaultRegionForCustomEndpoint is the region used to sign requests to
+	// S3 compatible services (Ceph RGW, MinIO, etc.) when neither the user
+	// nor the cluster provide one. Such services usually ignore the region,
+	// but both the AWS SDK and the registry refuse to work without it.
+	defaultRegionForCustomEndpoint = "us-east-1"
+
+	// fipsEnabledPath is the file that tells whether the kernel runs in FIPS
+	// mode.
+	fipsEnabledPath = "/proc/sys/crypto/fips_enabled"
 )
 
 type endpointsResolver struct {
@@ -98,6 +109,33 @@ func regionHasDualStackS3(region string) (bool, error) {
 	return true, nil
 }
 
+// hostFIPSEnabled returns true if the host kernel runs in FIPS mode. On
+// OpenShift all nodes of a FIPS cluster run in this mode.
+func hostFIPSEnabled() (bool, error) {
+	data, err := os.ReadFile(fipsEnabledPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
+
+// fipsEndpoint returns the FIPS S3 endpoint for the region. The dual-stack
+// endpoint is preferred when the region has one. Only endpoints that are
+// explicitly known for the region are used, the resolver would otherwise
+// build a hostname from the partition template that may not exist.
+func fipsEndpoint(region string) (string, error) {
+	ep, err := endpoints.DefaultResolver().EndpointFor("s3", region, endpoints.UseFIPSEndpointOption, endpoints.UseDualStackEndpointOption, endpoints.StrictMatchingOption)
+	if isUnknownEndpointError(err) {
+		ep, err = endpoints.DefaultResolver().EndpointFor("s3", region, endpoints.UseFIPSEndpointOption, endpoints.StrictMatchingOption)
+	}
+	if err != nil {
+		return "", err
+	}
+	return ep.URL, nil
+}
+
 type driver struct {
 	Context context.Context
 	Config  *imageregistryv1.ImageRegistryConfigStorageS3
@@ -107,8 +145,22 @@ type driver struct {
 	// account the cluster configuration.
 	endpointsResolver *endpointsResolver
 
+	// fipsRegionEndpoint is populated by UpdateEffectiveConfig when the
+	// cluster runs in FIPS mode and no endpoint is configured. It is not a
+	// part of Config, so that it is never persisted into the config object.
+	fipsRegionEndpoint string
+
+	// fipsEnabled and fipsEndpointIssue are populated by
+	// UpdateEffectiveConfig. fipsEndpointIssue explains why the endpoint
+	// cannot be FIPS compliant while the cluster runs in FIPS mode.
+	fipsEnabled       bool
+	fipsEndpointIssue error
+
 	// roundTripper is used only during tests.
 	roundTripper http.RoundTripper
+
+	// customizeHandlers is used only during tests.
+	customizeHandlers func(*request.Handlers)
 }
 
 // NewDriver creates a new s3 storage driver
@@ -159,13 +211,70 @@ func (d *driver) UpdateEffectiveConfig() error {
 		}
 	}
 
+	if len(effectiveConfig.RegionEndpoint) != 0 && len(effectiveConfig.Region) == 0 {
+		effectiveConfig.Region = clusterRegion
+		if len(effectiveConfig.Region) == 0 {
+			effectiveConfig.Region = defaultRegionForCustomEndpoint
+		}
+	}
+
+	fipsEnabled := d.Listers.FIPSEnabled
+	if fipsEnabled == nil {
+		fipsEnabled = hostFIPSEnabled
+	}
+	fips, err := fipsEnabled()
+	if err != nil {
+		return fmt.Errorf("unable to check if FIPS mode is enabled: %w", err)
+	}
+	var fipsRegionEndpoint string
+	var fipsEndpointIssue error
+	if fips {
+		if len(effectiveConfig.RegionEndpoint) == 0 {
+			// Clusters in regions without a FIPS endpoint have always used
+			// the regional endpoint, keep using it and report the gap.
+			fipsRegionEndpoint, err = fipsEndpoint(effectiveConfig.Region)
+			if err != nil {
+				fipsRegionEndpoint = ""
+				fipsEndpointIssue = fmt.Errorf("there is no FIPS endpoint for S3 in the region %q, the regional endpoint is used; set spec.storage.s3.regionEndpoint to a FIPS compliant endpoint: %w", effectiveConfig.Region, err)
+			}
+		} else if u, err := url.Parse(effectiveConfig.RegionEndpoint); err != nil || u.Scheme != "https" {
+			// Only a non-compliant endpoint that the user asked for is
+			// refused, the one from the cluster configuration is reported.
+			if effectiveConfig.RegionEndpoint != clusterRegionEndpoint {
+				return &util.InvalidConfigurationError{
+					Err: fmt.Errorf("FIPS mode is enabled, but the S3 endpoint %q does not use https", effectiveConfig.RegionEndpoint),
+				}
+			}
+			fipsEndpointIssue = fmt.Errorf("the S3 endpoint %q from the cluster configuration does not use https", effectiveConfig.RegionEndpoint)
+		}
+	}
+
 	d.Config = effectiveConfig.DeepCopy()
+	d.fipsRegionEndpoint = fipsRegionEndpoint
+	d.fipsEnabled = fips
+	d.fipsEndpointIssue = fipsEndpointIssue
 
-	d.endpointsResolver = newEndpointsResolver(d.Config.Region, d.Config.RegionEndpoint, clusterServiceEndpoints)
+	d.endpointsResolver = newEndpointsResolver(d.Config.Region, d.regionEndpoint(), clusterServiceEndpoints)
 
 	return nil
 }
 
+// updateFIPSEndpointCondition reports whether the storage is accessed through
+// a FIPS compliant endpoint. It doesn't degrade the operator: clusters in
+// regions without such an endpoint keep working as they did before FIPS
+// endpoints were used.
+func (d *driver) updateFIPSEndpointCondition(cr *imageregistryv1.Config) {
+	if !d.fipsEnabled {
+		return
+	}
+	if d.fipsEndpointIssue != nil {
+		klog.Warningf("the image registry storage doesn't use a FIPS compliant endpoint: %v", d.fipsEndpointIssue)
+		util.UpdateCondition(cr, defaults.StorageFIPSEndpoint, operatorapi.ConditionFalse, "FIPS Endpoint Unavailable", d.fipsEndpointIssue.Error())
+		return
+	}
+	util.UpdateCondition(cr, defaults.StorageFIPSEndpoint, operatorapi.ConditionTrue, "FIPS Endpoint Used", "")
+}
+
 // GetCredentialsFile will create and return the location of an AWS config file that can
 // be used to create AWS clients with. Caller is responsible for cleaning up the file.
 // sharedCredentialsFile, err := d.GetCredentialsFile()
@@ -213,15 +322,22 @@ func (d *driver) getCredentialsConfigData() ([]byte, error) {
 		if v, ok := sec.Data["REGISTRY_STORAGE_S3_ACCESSKEY"]; ok {
 			accessKey = string(v)
 		} else {
-			return nil, fmt.Errorf("secret %q does not contain required key \"REGISTRY_STORAGE_S3_ACCESSKEY\"", fmt.Sprintf("%s/%s", defaults.ImageRegistryOperatorNamespace, defaults.ImageRegistryPrivateConfigurationUser))
+			return nil, &util.InvalidConfigurationError{
+				Err: fmt.Errorf("secret %q does not contain required key \"REGISTRY_STORAGE_S3_ACCESSKEY\"", fmt.Sprintf("%s/%s", defaults.ImageRegistryOperatorNamespace, defaults.ImageRegistryPrivateConfigurationUser)),
+			}
 		}
 		if v, ok := sec.Data["REGISTRY_STORAGE_S3_SECRETKEY"]; ok {
 			secretKey = string(v)
 		} else {
-			return nil, fmt.Errorf("secret %q does not contain required key \"REGISTRY_STORAGE_S3_SECRETKEY\"", fmt.Sprintf("%s/%s", defaults.ImageRegistryOperatorNamespace, defaults.ImageRegistryPrivateConfigurationUser))
+			return nil, &util.InvalidConfigurationError{
+				Err: fmt.Errorf("secret %q does not contain required key \"REGISTRY_STORAGE_S3_SECRETKEY\"", fmt.Sprintf("%s/%s", defaults.ImageRegistryOperatorNamespace, defaults.ImageRegistryPrivateConfigurationUser)),
+			}
 		}
+		// The session token is optional, it is only needed for temporary
+		// credentials issued by STS.
+		sessionToken := string(sec.Data["REGISTRY_STORAGE_S3_SESSIONTOKEN"])
 
-		return sharedCredentialsDataFromStaticCreds(accessKey, secretKey), nil
+		return sharedCredentialsDataFromStaticCreds(accessKey, secretKey, sessionToken), nil
 	}
 }
 
@@ -256,9 +372,24 @@ func (d *driver) CABundle() (string, bool, error) {
 	}
 }
 
+// regionEndpoint returns the endpoint that should be used to reach S3, if
+// it is not the default one for the region.
+func (d *driver) regionEndpoint() string {
+	if d.Config.RegionEndpoint != "" {
+		return d.Config.RegionEndpoint
+	}
+	return d.fipsRegionEndpoint
+}
+
+// virtualHostedStyle returns true if the bucket name should be a part of the
+// hostname. The FIPS endpoints require virtual hosted-style requests.
+func (d *driver) virtualHostedStyle() bool {
+	return d.Config.VirtualHostedStyle || (d.Config.RegionEndpoint == "" && d.fipsRegionEndpoint != "")
+}
+
 // useDualStack returns true if the driver should use dual-stack endpoints
 func (d *driver) useDualStack() (bool, error) {
-	if d.Config.RegionEndpoint != "" {
+	if d.regionEndpoint() != "" {
 		return true, nil
 	}
 	ok, err := regionHasDualStackS3(d.Config.Region)
@@ -348,8 +479,8 @@ func (d *driver) getS3Service() (*s3.S3, error) {
 		awsOptions.Config.WithUseDualStack(true)
 	}
 
-	if d.Config.RegionEndpoint != "" {
-		if !d.Config.VirtualHostedStyle {
+	if d.regionEndpoint() != "" {
+		if !d.virtualHostedStyle() {
 			awsOptions.Config.WithS3ForcePathStyle(true)
 		}
 	}
@@ -364,6 +495,9 @@ func (d *driver) getS3Service() (*s3.S3, error) {
 		Name: "openshift.io/cluster-image-registry-operator",
 		Fn:   request.MakeAddToUserAgentHandler("openshift.io cluster-image-registry-operator", version.Version),
 	})
+	if d.customizeHandlers != nil {
+		d.customizeHandlers(&sess.Handlers)
+	}
 
 	return s3.New(sess), nil
 }
@@ -390,6 +524,22 @@ func isBucketNotFound(err interface{}) bool {
 	return false
 }
 
+// isAccessDenied returns true if S3 rejected the request with 403 Forbidden.
+func isAccessDenied(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	return ok && reqErr.StatusCode() == http.StatusForbidden
+}
+
+// hasTag returns true if tagset contains a tag with the given key.
+func hasTag(tagset []*s3.Tag, key string) bool {
+	for _, tag := range tagset {
+		if aws.StringValue(tag.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
 // ConfigEnv configures the environment variables that will be
 // used in the image registry deployment, and returns an AWS credentials file
 // that can be used for setting up an AWS session/client.
@@ -401,8 +551,8 @@ func (d *driver) ConfigEnv() (envs envvar.List, err error) {
 		return
 	}
 
-	if len(d.Config.RegionEndpoint) != 0 {
-		envs = append(envs, envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_REGIONENDPOINT", Value: d.Config.RegionEndpoint})
+	if regionEndpoint := d.regionEndpoint(); len(regionEndpoint) != 0 {
+		envs = append(envs, envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_REGIONENDPOINT", Value: regionEndpoint})
 	}
 
 	if len(d.Config.KeyID) != 0 {
@@ -414,7 +564,7 @@ func (d *driver) ConfigEnv() (envs envvar.List, err error) {
 		envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_BUCKET", Value: d.Config.Bucket},
 		envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_REGION", Value: d.Config.Region},
 		envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_ENCRYPT", Value: d.Config.Encrypt},
-		envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_VIRTUALHOSTEDSTYLE", Value: d.Config.VirtualHostedStyle},
+		envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_VIRTUALHOSTEDSTYLE", Value: d.virtualHostedStyle()},
 		envvar.EnvVar{Name: "REGISTRY_STORAGE_S3_CREDENTIALSCONFIGPATH", Value: filepath.Join(imageRegistrySecretMountpoint, imageRegistrySecretDataKey)},
 	)
 
@@ -559,6 +709,7 @@ func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 	}
 
 	err := d.bucketExists(d.Config.Bucket)
+	d.updateFIPSEndpointCondition(cr)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
@@ -571,10 +722,45 @@ func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 		return false, err
 	}
 
+	// The bucket has to be set up again until the permissions check passes.
+	if util.PermissionsCheckFailed(cr) {
+		return false, nil
+	}
+
 	util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionTrue, "S3 Bucket Exists", "")
 	return true, nil
 }
 
+// VerifyPermissions checks that the credentials allow the image registry to
+// write and delete objects in the bucket. It writes a small object under the
+// prefix used by the image registry and removes it.
+func (d *driver) VerifyPermissions() error {
+	svc, err := d.getS3Service()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutObjectWithContext(d.Context, &s3.PutObjectInput{
+		Bucket: aws.String(d.Config.Bucket),
+		Key:    aws.String(util.PermissionsCheckObjectName),
+		Body:   bytes.NewReader(nil),
+	})
+	if isAccessDenied(err) {
+		return &util.InsufficientPermissionsError{Action: "s3:PutObject", Err: err}
+	} else if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteObjectWithContext(d.Context, &s3.DeleteObjectInput{
+		Bucket: aws.String(d.Config.Bucket),
+		Key:    aws.String(util.PermissionsCheckObjectName),
+	})
+	if isAccessDenied(err) {
+		return &util.InsufficientPermissionsError{Action: "s3:DeleteObject", Err: err}
+	}
+	return err
+}
+
 // StorageChanged checks to see if the name of the storage medium
 // has changed
 func (d *driver) StorageChanged(cr *imageregistryv1.Config) bool {
@@ -602,6 +788,7 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 	if err := d.UpdateEffectiveConfig(); err != nil {
 		return err
 	}
+	d.updateFIPSEndpointCondition(cr)
 
 	// If a bucket name is supplied, and it already exists and we can access it
 	// just update the config
@@ -668,6 +855,9 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 						util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, aerr.Code(), aerr.Error())
 						return err
 					}
+				} else {
+					util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionUnknown, "Unknown Error Occurred", err.Error())
+					return err
 				}
 			}
 			if cr.Spec.Storage.ManagementState == "" {
@@ -748,6 +938,12 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 		if hasAWSStatus {
 			klog.Infof("user provided %d tags", len(infra.Status.PlatformStatus.AWS.ResourceTags))
 			for _, tag := range infra.Status.PlatformStatus.AWS.ResourceTags {
+				// S3 rejects tag sets with duplicate keys, the tags set by
+				// the operator take precedence.
+				if hasTag(tagset, tag.Key) {
+					klog.Infof("ignoring user provided bucket tag %s: the key is reserved by the operator", tag.Key)
+					continue
+				}
 				klog.Infof("user provided bucket tag: %s: %s", tag.Key, tag.Value)
 				tagset = append(tagset, &s3.Tag{
 					Key:   aws.String(tag.Key),
@@ -858,6 +1054,13 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 		}
 	}
 
+	// Check once that the registry will be able to push into the bucket.
+	// The check object is kept under the prefix used by the registry, so
+	// buckets that are not managed by the operator are checked as well.
+	if err := util.VerifyPermissions(cr, d.VerifyPermissions); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -956,17 +1159,21 @@ func sharedCredentialsDataFromSecret(secret *corev1.Secret) ([]byte, error) {
 	case len(secret.Data["aws_access_key_id"]) > 0 && len(secret.Data["aws_secret_access_key"]) > 0:
 		accessKey := string(secret.Data["aws_access_key_id"])
 		secretKey := string(secret.Data["aws_secret_access_key"])
-		return sharedCredentialsDataFromStaticCreds(accessKey, secretKey), nil
+		sessionToken := string(secret.Data["aws_session_token"])
+		return sharedCredentialsDataFromStaticCreds(accessKey, secretKey, sessionToken), nil
 	default:
 		return nil, fmt.Errorf("invalid secret for aws credentials")
 	}
 }
 
-func sharedCredentialsDataFromStaticCreds(accessKey, accessSecret string) []byte {
+func sharedCredentialsDataFromStaticCreds(accessKey, accessSecret, sessionToken string) []byte {
 	buf := &bytes.Buffer{}
 	fmt.Fprint(buf, "[default]\n")
 	fmt.Fprintf(buf, "aws_access_key_id = %s\n", accessKey)
 	fmt.Fprintf(buf, "aws_secret_access_key = %s\n", accessSecret)
+	if len(sessionToken) > 0 {
+		fmt.Fprintf(buf, "aws_session_token = %s\n", sessionToken)
+	}
 
 	return buf.Bytes()
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"path/filepath"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/private/protocol/xml/xmlutil"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/google/go-cmp/cmp"
@@ -22,10 +25,12 @@ import (
 
 	configv1 "github.com/openshift/api/config/v1"
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	operatorapi "github.com/openshift/api/operator/v1"
 
 	cirofake "github.com/openshift/cluster-image-registry-operator/pkg/client/fake"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
 	"github.com/openshift/cluster-image-registry-operator/pkg/envvar"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 )
 
 func TestEndpointsResolver(t *testing.T) {
@@ -52,6 +57,15 @@ func TestEndpointsResolver(t *testing.T) {
 			useDualStack: true,
 			endpoint:     "https://s3.dualstack.us-gov-east-1.amazonaws.com",
 		},
+		{
+			region:   "cn-north-1",
+			endpoint: "https://s3.cn-north-1.amazonaws.com.cn",
+		},
+		{
+			region:       "cn-northwest-1",
+			useDualStack: true,
+			endpoint:     "https://s3.dualstack.cn-northwest-1.amazonaws.com.cn",
+		},
 		{
 			region:   "us-iso-east-1",
 			endpoint: "https://s3.us-iso-east-1.c2s.ic.gov",
@@ -96,6 +110,10 @@ func TestRegionS3DualStack(t *testing.T) {
 			region: "us-gov-east-1",
 			want:   true,
 		},
+		{
+			region: "cn-north-1",
+			want:   true,
+		},
 		{
 			region: "us-iso-east-1",
 			want:   false,
@@ -216,6 +234,230 @@ func TestGetConfigCustomRegionEndpoint(t *testing.T) {
 	}
 }
 
+func TestGetConfigRegionEndpointWithoutRegion(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		platformStatus *configv1.PlatformStatus
+		expectedRegion string
+	}{
+		{
+			name: "aws cluster",
+			platformStatus: &configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS: &configv1.AWSPlatformStatus{
+					Region: "eu-west-1",
+				},
+			},
+			expectedRegion: "eu-west-1",
+		},
+		{
+			name: "non-aws cluster",
+			platformStatus: &configv1.PlatformStatus{
+				Type: configv1.BareMetalPlatformType,
+			},
+			expectedRegion: defaultRegionForCustomEndpoint,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testBuilder := cirofake.NewFixturesBuilder()
+			testBuilder.AddInfraConfig(&configv1.Infrastructure{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+				Status: configv1.InfrastructureStatus{
+					PlatformStatus: tt.platformStatus,
+				},
+			})
+			listers := testBuilder.BuildListers()
+
+			s3Driver := &driver{
+				Listers: &listers.StorageListers,
+				Config: &imageregistryv1.ImageRegistryConfigStorageS3{
+					RegionEndpoint: "https://minio.example.com",
+				},
+			}
+			if err := s3Driver.UpdateEffectiveConfig(); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region:         tt.expectedRegion,
+				RegionEndpoint: "https://minio.example.com",
+			}
+			if !reflect.DeepEqual(s3Driver.Config, expected) {
+				t.Errorf("unexpected config: %s", cmp.Diff(expected, s3Driver.Config))
+			}
+		})
+	}
+}
+
+func TestGetConfigFIPS(t *testing.T) {
+	for _, tt := range []struct {
+		name                       string
+		config                     *imageregistryv1.ImageRegistryConfigStorageS3
+		expected                   *imageregistryv1.ImageRegistryConfigStorageS3
+		expectedRegionEndpoint     string
+		expectedVirtualHostedStyle bool
+		expectedErrSubstr          string
+		expectedFIPSIssue          string
+		serviceEndpoints           []configv1.AWSServiceEndpoint
+	}{
+		{
+			name:   "cluster region",
+			config: &imageregistryv1.ImageRegistryConfigStorageS3{},
+			expected: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region: "us-east-1",
+			},
+			expectedRegionEndpoint:     "https://s3-fips.dualstack.us-east-1.amazonaws.com",
+			expectedVirtualHostedStyle: true,
+		},
+		{
+			name: "region without dual-stack endpoints",
+			config: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region: "us-gov-west-1",
+			},
+			expected: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region: "us-gov-west-1",
+			},
+			expectedRegionEndpoint:     "https://s3-fips.us-gov-west-1.amazonaws.com",
+			expectedVirtualHostedStyle: true,
+		},
+		{
+			name: "region without FIPS endpoints",
+			config: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region: "eu-west-1",
+			},
+			expected: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region: "eu-west-1",
+			},
+			expectedFIPSIssue: `there is no FIPS endpoint for S3 in the region "eu-west-1"`,
+		},
+		{
+			name: "partition without FIPS endpoints",
+			config: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region: "cn-north-1",
+			},
+			expected: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region: "cn-north-1",
+			},
+			expectedFIPSIssue: `there is no FIPS endpoint for S3 in the region "cn-north-1"`,
+		},
+		{
+			name: "custom https endpoint",
+			config: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region:         "us-east-1",
+				RegionEndpoint: "https://s3.example.com",
+			},
+			expected: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region:         "us-east-1",
+				RegionEndpoint: "https://s3.example.com",
+			},
+			expectedRegionEndpoint: "https://s3.example.com",
+		},
+		{
+			name: "custom http endpoint",
+			config: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region:         "us-east-1",
+				RegionEndpoint: "http://s3.example.com",
+			},
+			expectedErrSubstr: "does not use https",
+		},
+		{
+			name:   "cluster http endpoint",
+			config: &imageregistryv1.ImageRegistryConfigStorageS3{},
+			serviceEndpoints: []configv1.AWSServiceEndpoint{
+				{Name: "s3", URL: "http://s3.example.com"},
+			},
+			expected: &imageregistryv1.ImageRegistryConfigStorageS3{
+				Region:             "us-east-1",
+				RegionEndpoint:     "http://s3.example.com",
+				VirtualHostedStyle: true,
+			},
+			expectedRegionEndpoint:     "http://s3.example.com",
+			expectedVirtualHostedStyle: true,
+			expectedFIPSIssue:          "does not use https",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testBuilder := cirofake.NewFixturesBuilder()
+			testBuilder.AddInfraConfig(&configv1.Infrastructure{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+				Status: configv1.InfrastructureStatus{
+					PlatformStatus: &configv1.PlatformStatus{
+						Type: configv1.AWSPlatformType,
+						AWS: &configv1.AWSPlatformStatus{
+							Region:           "us-east-1",
+							ServiceEndpoints: tt.serviceEndpoints,
+						},
+					},
+				},
+			})
+			listers := testBuilder.BuildListers()
+			listers.StorageListers.FIPSEnabled = func() (bool, error) {
+				return true, nil
+			}
+
+			s3Driver := &driver{
+				Listers: &listers.StorageListers,
+				Config:  tt.config,
+			}
+			err := s3Driver.UpdateEffectiveConfig()
+			if tt.expectedErrSubstr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErrSubstr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErrSubstr, err)
+				}
+				var invalidConfigErr *util.InvalidConfigurationError
+				if !errors.As(err, &invalidConfigErr) {
+					t.Errorf("expected an invalid configuration error, got %#+v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expectedFIPSIssue == "" && s3Driver.fipsEndpointIssue != nil {
+				t.Errorf("unexpected FIPS endpoint issue: %v", s3Driver.fipsEndpointIssue)
+			} else if tt.expectedFIPSIssue != "" && (s3Driver.fipsEndpointIssue == nil || !strings.Contains(s3Driver.fipsEndpointIssue.Error(), tt.expectedFIPSIssue)) {
+				t.Errorf("expected FIPS endpoint issue containing %q, got %v", tt.expectedFIPSIssue, s3Driver.fipsEndpointIssue)
+			}
+
+			// The missing FIPS endpoint is reported, but it isn't an error.
+			cr := &imageregistryv1.Config{}
+			s3Driver.updateFIPSEndpointCondition(cr)
+			expectedStatus := operatorapi.ConditionTrue
+			if tt.expectedFIPSIssue != "" {
+				expectedStatus = operatorapi.ConditionFalse
+			}
+			if cond := util.FetchCondition(cr, defaults.StorageFIPSEndpoint); cond.Status != expectedStatus {
+				t.Errorf("expected %s condition to be %s, got %#+v", defaults.StorageFIPSEndpoint, expectedStatus, cond)
+			}
+
+			// The FIPS endpoint is derived from the region, it should not
+			// end up in the config object.
+			if !reflect.DeepEqual(s3Driver.Config, tt.expected) {
+				t.Errorf("unexpected config: %s", cmp.Diff(tt.expected, s3Driver.Config))
+			}
+
+			envs, err := s3Driver.ConfigEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var regionEndpoint interface{} = ""
+			if env := findEnvVar(envs, "REGISTRY_STORAGE_S3_REGIONENDPOINT"); env != nil {
+				regionEndpoint = env.Value
+			}
+			if regionEndpoint != tt.expectedRegionEndpoint {
+				t.Errorf("expected REGISTRY_STORAGE_S3_REGIONENDPOINT to be %q, got %v", tt.expectedRegionEndpoint, regionEndpoint)
+			}
+			if env := findEnvVar(envs, "REGISTRY_STORAGE_S3_VIRTUALHOSTEDSTYLE"); env == nil || env.Value != tt.expectedVirtualHostedStyle {
+				t.Errorf("expected REGISTRY_STORAGE_S3_VIRTUALHOSTEDSTYLE to be %t, got %#+v", tt.expectedVirtualHostedStyle, env)
+			}
+		})
+	}
+}
+
 func findEnvVar(envvars envvar.List, name string) *envvar.EnvVar {
 	for i, e := range envvars {
 		if e.Name == name {
@@ -281,6 +523,219 @@ func TestConfigEnv(t *testing.T) {
 	}
 }
 
+func TestConfigEnvDualStack(t *testing.T) {
+	for _, tt := range []struct {
+		region       string
+		useDualStack bool
+	}{
+		{
+			region:       "us-east-1",
+			useDualStack: true,
+		},
+		{
+			region:       "us-gov-west-1",
+			useDualStack: true,
+		},
+		{
+			region:       "us-iso-east-1",
+			useDualStack: false,
+		},
+	} {
+		t.Run(tt.region, func(t *testing.T) {
+			testBuilder := cirofake.NewFixturesBuilder()
+			testBuilder.AddInfraConfig(&configv1.Infrastructure{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+				Status: configv1.InfrastructureStatus{
+					PlatformStatus: &configv1.PlatformStatus{
+						Type: configv1.AWSPlatformType,
+						AWS: &configv1.AWSPlatformStatus{
+							Region: tt.region,
+						},
+					},
+				},
+			})
+			listers := testBuilder.BuildListers()
+
+			d := NewDriver(context.Background(), &imageregistryv1.ImageRegistryConfigStorageS3{}, &listers.StorageListers)
+
+			envvars, err := d.ConfigEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := findEnvVar(envvars, "REGISTRY_STORAGE_S3_USEDUALSTACK")
+			if tt.useDualStack && (e == nil || e.Value != true) {
+				t.Errorf("REGISTRY_STORAGE_S3_USEDUALSTACK: got %v, want true", e)
+			}
+			if !tt.useDualStack && e != nil {
+				t.Errorf("REGISTRY_STORAGE_S3_USEDUALSTACK is expected to be unset, but got %v", e)
+			}
+		})
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		trustedCA         string
+		configMaps        []*corev1.ConfigMap
+		expectedBundle    string
+		expectedSystem    bool
+		expectedErrSubstr string
+	}{
+		{
+			name:           "no custom bundle",
+			expectedSystem: true,
+		},
+		{
+			name: "bundle from the kube cloud config",
+			configMaps: []*corev1.ConfigMap{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      defaults.KubeCloudConfigName,
+						Namespace: defaults.OpenShiftConfigManagedNamespace,
+					},
+					Data: map[string]string{
+						defaults.CloudCABundleKey: "cloud-ca",
+					},
+				},
+			},
+			expectedBundle: "cloud-ca",
+			expectedSystem: true,
+		},
+		{
+			name:      "bundle from the trusted CA config map",
+			trustedCA: "s3-ca",
+			configMaps: []*corev1.ConfigMap{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "s3-ca",
+						Namespace: defaults.OpenShiftConfigNamespace,
+					},
+					Data: map[string]string{
+						"ca-bundle.crt": "private-ca",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      defaults.KubeCloudConfigName,
+						Namespace: defaults.OpenShiftConfigManagedNamespace,
+					},
+					Data: map[string]string{
+						defaults.CloudCABundleKey: "cloud-ca",
+					},
+				},
+			},
+			expectedBundle: "private-ca",
+		},
+		{
+			name:      "trusted CA config map without the bundle key",
+			trustedCA: "s3-ca",
+			configMaps: []*corev1.ConfigMap{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "s3-ca",
+						Namespace: defaults.OpenShiftConfigNamespace,
+					},
+					Data: map[string]string{
+						"ca.crt": "private-ca",
+					},
+				},
+			},
+			expectedErrSubstr: `does not contain required key "ca-bundle.crt"`,
+		},
+		{
+			name:              "missing trusted CA config map",
+			trustedCA:         "s3-ca",
+			expectedErrSubstr: `failed to get trusted CA "s3-ca"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			listers := cirofake.NewFixturesBuilder().AddConfigMaps(tt.configMaps...).BuildListers()
+
+			d := NewDriver(context.Background(), &imageregistryv1.ImageRegistryConfigStorageS3{
+				TrustedCA: imageregistryv1.S3TrustedCASource{
+					Name: tt.trustedCA,
+				},
+			}, &listers.StorageListers)
+
+			bundle, system, err := d.CABundle()
+			if tt.expectedErrSubstr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErrSubstr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErrSubstr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bundle != tt.expectedBundle {
+				t.Errorf("bundle: got %q, want %q", bundle, tt.expectedBundle)
+			}
+			if system != tt.expectedSystem {
+				t.Errorf("use system cert pool: got %t, want %t", system, tt.expectedSystem)
+			}
+		})
+	}
+}
+
+func TestVolumesCloudFront(t *testing.T) {
+	config := &imageregistryv1.ImageRegistryConfigStorageS3{
+		Bucket: "bucket",
+		Region: "us-east-1",
+		CloudFront: &imageregistryv1.ImageRegistryConfigStorageS3CloudFront{
+			BaseURL:   "https://cloudfront.example.com",
+			KeypairID: "keypair-id",
+			PrivateKey: corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: "cloudfront-secret",
+				},
+				Key: "private-key",
+			},
+		},
+	}
+
+	listers := cirofake.NewFixturesBuilder().BuildListers()
+	d := NewDriver(context.Background(), config, &listers.StorageListers)
+
+	volumes, mounts, err := d.Volumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vol *corev1.Volume
+	for i := range volumes {
+		if volumes[i].Name == "registry-cloudfront" {
+			vol = &volumes[i]
+		}
+	}
+	if vol == nil {
+		t.Fatalf("volume registry-cloudfront not found, %v", volumes)
+	}
+	if vol.Secret == nil || vol.Secret.SecretName != "cloudfront-secret" {
+		t.Fatalf("expected volume to use secret cloudfront-secret, got %#+v", vol.VolumeSource)
+	}
+	expectedItems := []corev1.KeyToPath{{Key: "private-key", Path: "private.pem"}}
+	if !reflect.DeepEqual(vol.Secret.Items, expectedItems) {
+		t.Errorf("unexpected secret items: got %#+v, want %#+v", vol.Secret.Items, expectedItems)
+	}
+
+	var mount *corev1.VolumeMount
+	for i := range mounts {
+		if mounts[i].Name == "registry-cloudfront" {
+			mount = &mounts[i]
+		}
+	}
+	if mount == nil {
+		t.Fatalf("volume mount registry-cloudfront not found, %v", mounts)
+	}
+	if mount.MountPath != "/etc/docker/cloudfront" || !mount.ReadOnly {
+		t.Errorf("unexpected volume mount: %#+v", mount)
+	}
+}
+
 func TestServiceEndpointCanBeOverwritten(t *testing.T) {
 	ctx := context.Background()
 
@@ -384,7 +839,16 @@ func (r *tripper) AddResponse(code int) {
 	r.responseCodes = append(r.responseCodes, code)
 }
 
+// ignoreCABundle unsets AWS_CA_BUNDLE for the test. The SDK fails to load a
+// custom CA bundle when the transport of the driver is replaced by a
+// tripper.
+func ignoreCABundle(t *testing.T) {
+	t.Setenv("AWS_CA_BUNDLE", "")
+}
+
 func TestStorageManagementState(t *testing.T) {
+	ignoreCABundle(t)
+
 	builder := cirofake.NewFixturesBuilder()
 	builder.AddInfraConfig(&configv1.Infrastructure{
 		ObjectMeta: metav1.ObjectMeta{
@@ -525,7 +989,50 @@ func TestStorageManagementState(t *testing.T) {
 	}
 }
 
+func TestRemoveStorageKeepsUnmanagedBucket(t *testing.T) {
+	for _, state := range []string{
+		"",
+		imageregistryv1.StorageManagementStateUnmanaged,
+	} {
+		t.Run(fmt.Sprintf("managementState=%q", state), func(t *testing.T) {
+			config := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						ManagementState: state,
+						S3: &imageregistryv1.ImageRegistryConfigStorageS3{
+							Bucket: "users-bucket",
+							Region: "us-west-1",
+						},
+					},
+				},
+			}
+
+			listers := cirofake.NewFixturesBuilder().BuildListers()
+			drv := NewDriver(context.Background(), config.Spec.Storage.S3, &listers.StorageListers)
+
+			rt := &tripper{}
+			drv.roundTripper = rt
+
+			removed, err := drv.RemoveStorage(config)
+			if err != nil {
+				t.Fatalf("unexpected err %q", err)
+			}
+			if removed {
+				t.Errorf("expected the bucket not to be removed")
+			}
+			if rt.req != 0 {
+				t.Errorf("expected no requests to S3, got %d", rt.req)
+			}
+			if config.Spec.Storage.S3.Bucket != "users-bucket" {
+				t.Errorf("expected the bucket to stay in the spec, got %q", config.Spec.Storage.S3.Bucket)
+			}
+		})
+	}
+}
+
 func TestUserProvidedTags(t *testing.T) {
+	ignoreCABundle(t)
+
 	for _, tt := range []struct {
 		name          string
 		config        *imageregistryv1.Config
@@ -596,21 +1103,56 @@ func TestUserProvidedTags(t *testing.T) {
 			},
 		},
 		{
-			name:      "with user tags and unmanaged storage",
+			name:      "with user tags overlapping operator tags",
 			infraName: "tinfra",
 			userTags: []configv1.AWSResourceTag{
+				{
+					Key:   "Name",
+					Value: "custom-name",
+				},
 				{
 					Key:   "tag0",
 					Value: "value0",
 				},
+			},
+			expectedTags: []*s3.Tag{
 				{
-					Key:   "tag1",
-					Value: "value1",
+					Key:   aws.String("kubernetes.io/cluster/tinfra"),
+					Value: aws.String("owned"),
 				},
-			},
-			noTagRequest: true,
-			expectedTags: []*s3.Tag{},
-			config: &imageregistryv1.Config{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String("tinfra-image-registry"),
+				},
+				{
+					Key:   aws.String("tag0"),
+					Value: aws.String("value0"),
+				},
+			},
+			config: &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						S3: &imageregistryv1.ImageRegistryConfigStorageS3{},
+					},
+				},
+			},
+		},
+		{
+			name:      "with user tags and unmanaged storage",
+			infraName: "tinfra",
+			userTags: []configv1.AWSResourceTag{
+				{
+					Key:   "tag0",
+					Value: "value0",
+				},
+				{
+					Key:   "tag1",
+					Value: "value1",
+				},
+			},
+			noTagRequest: true,
+			expectedTags: []*s3.Tag{},
+			config: &imageregistryv1.Config{
 				Spec: imageregistryv1.ImageRegistrySpec{
 					Storage: imageregistryv1.ImageRegistryConfigStorage{
 						ManagementState: "Unmanaged",
@@ -780,3 +1322,556 @@ func TestUserProvidedTags(t *testing.T) {
 		})
 	}
 }
+
+func TestKMSEncryption(t *testing.T) {
+	ignoreCABundle(t)
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "tinfra",
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS: &configv1.AWSPlatformStatus{
+					Region: "us-west-1",
+				},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"aws_access_key_id":     []byte("access_key_id"),
+			"aws_secret_access_key": []byte("secret_access_key"),
+		},
+	})
+	listers := builder.BuildListers()
+
+	for _, tt := range []struct {
+		name              string
+		keyID             string
+		expectedAlgorithm string
+	}{
+		{
+			name:              "default encryption",
+			expectedAlgorithm: s3.ServerSideEncryptionAes256,
+		},
+		{
+			name:              "kms key",
+			keyID:             "arn:aws:kms:us-west-1:123456789012:key/some-key",
+			expectedAlgorithm: s3.ServerSideEncryptionAwsKms,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &imageregistryv1.Config{
+				Spec: imageregistryv1.ImageRegistrySpec{
+					Storage: imageregistryv1.ImageRegistryConfigStorage{
+						ManagementState: imageregistryv1.StorageManagementStateManaged,
+						S3: &imageregistryv1.ImageRegistryConfigStorageS3{
+							Bucket: "a-bucket",
+							KeyID:  tt.keyID,
+						},
+					},
+				},
+			}
+
+			drv := NewDriver(context.Background(), cr.Spec.Storage.S3, &listers.StorageListers)
+			rt := &tripper{}
+			drv.roundTripper = rt
+
+			if err := drv.CreateStorage(cr); err != nil {
+				t.Fatalf("unexpected err %q", err)
+			}
+
+			var found bool
+			for _, body := range rt.reqBodies {
+				if !strings.Contains(string(body), "ServerSideEncryptionConfiguration") {
+					continue
+				}
+				found = true
+
+				sse := s3.ServerSideEncryptionConfiguration{}
+				xmldec := xml.NewDecoder(bytes.NewBuffer(body))
+				if err := xmlutil.UnmarshalXML(&sse, xmldec, ""); err != nil {
+					t.Fatalf("error decoding encryption request: %s", err)
+				}
+				if len(sse.Rules) != 1 {
+					t.Fatalf("expected exactly one encryption rule, got %d", len(sse.Rules))
+				}
+
+				rule := sse.Rules[0].ApplyServerSideEncryptionByDefault
+				if got := aws.StringValue(rule.SSEAlgorithm); got != tt.expectedAlgorithm {
+					t.Errorf("expected algorithm %q, got %q", tt.expectedAlgorithm, got)
+				}
+				if got := aws.StringValue(rule.KMSMasterKeyID); got != tt.keyID {
+					t.Errorf("expected kms key %q, got %q", tt.keyID, got)
+				}
+			}
+			if !found {
+				t.Fatal("no request for bucket encryption found")
+			}
+
+			if !cr.Spec.Storage.S3.Encrypt {
+				t.Errorf("expected encrypt to be enabled after the bucket encryption was configured")
+			}
+
+			envvars, err := drv.ConfigEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e := findEnvVar(envvars, "REGISTRY_STORAGE_S3_ENCRYPT"); e == nil || e.Value != true {
+				t.Errorf("REGISTRY_STORAGE_S3_ENCRYPT: got %v, want true", e)
+			}
+			e := findEnvVar(envvars, "REGISTRY_STORAGE_S3_KEYID")
+			switch {
+			case tt.keyID == "" && e != nil:
+				t.Errorf("REGISTRY_STORAGE_S3_KEYID is expected to be unset, but got %v", e)
+			case tt.keyID != "" && (e == nil || e.Value != tt.keyID):
+				t.Errorf("REGISTRY_STORAGE_S3_KEYID: got %v, want %q", e, tt.keyID)
+			}
+		})
+	}
+}
+
+func TestSharedCredentialsDataFromSecret(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		data     map[string][]byte
+		expected string
+	}{
+		{
+			name: "static credentials",
+			data: map[string][]byte{
+				"aws_access_key_id":     []byte("access"),
+				"aws_secret_access_key": []byte("secret"),
+			},
+			expected: "[default]\naws_access_key_id = access\naws_secret_access_key = secret\n",
+		},
+		{
+			name: "temporary credentials",
+			data: map[string][]byte{
+				"aws_access_key_id":     []byte("access"),
+				"aws_secret_access_key": []byte("secret"),
+				"aws_session_token":     []byte("token"),
+			},
+			expected: "[default]\naws_access_key_id = access\naws_secret_access_key = secret\naws_session_token = token\n",
+		},
+		{
+			name: "sts credentials file",
+			data: map[string][]byte{
+				"credentials": []byte("[default]\nrole_arn = arn:aws:iam::123456789012:role/registry\nweb_identity_token_file = /var/run/secrets/openshift/serviceaccount/token\n"),
+			},
+			expected: "[default]\nrole_arn = arn:aws:iam::123456789012:role/registry\nweb_identity_token_file = /var/run/secrets/openshift/serviceaccount/token\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := sharedCredentialsDataFromSecret(&corev1.Secret{Data: tt.data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.expected {
+				t.Errorf("unexpected credentials file: %s", cmp.Diff(tt.expected, string(data)))
+			}
+		})
+	}
+}
+
+func TestUserProvidedCredentialsMissingKey(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		data        map[string][]byte
+		expectedErr string
+	}{
+		{
+			name: "no access key",
+			data: map[string][]byte{
+				"REGISTRY_STORAGE_S3_SECRETKEY": []byte("secret"),
+			},
+			expectedErr: `does not contain required key "REGISTRY_STORAGE_S3_ACCESSKEY"`,
+		},
+		{
+			name: "no secret key",
+			data: map[string][]byte{
+				"REGISTRY_STORAGE_S3_ACCESSKEY": []byte("access"),
+			},
+			expectedErr: `does not contain required key "REGISTRY_STORAGE_S3_SECRETKEY"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			listers := cirofake.NewFixturesBuilder().AddSecrets(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      defaults.ImageRegistryPrivateConfigurationUser,
+					Namespace: defaults.ImageRegistryOperatorNamespace,
+				},
+				Data: tt.data,
+			}).BuildListers()
+			d := NewDriver(context.Background(), &imageregistryv1.ImageRegistryConfigStorageS3{}, &listers.StorageListers)
+
+			_, err := d.VolumeSecrets()
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Fatalf("expected error to contain %q, got %v", tt.expectedErr, err)
+			}
+			var invalidConfigErr *util.InvalidConfigurationError
+			if !errors.As(err, &invalidConfigErr) {
+				t.Errorf("expected an invalid configuration error, got %#+v", err)
+			}
+		})
+	}
+}
+
+func TestIncompleteUploadCleanup(t *testing.T) {
+	ignoreCABundle(t)
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "tinfra",
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS: &configv1.AWSPlatformStatus{
+					Region: "us-west-1",
+				},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"aws_access_key_id":     []byte("access_key_id"),
+			"aws_secret_access_key": []byte("secret_access_key"),
+		},
+	})
+	listers := builder.BuildListers()
+
+	cr := &imageregistryv1.Config{
+		Spec: imageregistryv1.ImageRegistrySpec{
+			Storage: imageregistryv1.ImageRegistryConfigStorage{
+				S3: &imageregistryv1.ImageRegistryConfigStorageS3{},
+			},
+		},
+	}
+
+	drv := NewDriver(context.Background(), cr.Spec.Storage.S3, &listers.StorageListers)
+	rt := &tripper{}
+	drv.roundTripper = rt
+
+	if err := drv.CreateStorage(cr); err != nil {
+		t.Fatalf("unexpected err %q", err)
+	}
+
+	if cr.Status.Storage.S3 == nil || len(cr.Status.Storage.S3.Bucket) == 0 {
+		t.Fatalf("expected the generated bucket name to be recorded in status, got %#+v", cr.Status.Storage.S3)
+	}
+	if cr.Status.Storage.S3.Bucket != cr.Spec.Storage.S3.Bucket {
+		t.Errorf("expected spec and status buckets to match, got %q and %q", cr.Spec.Storage.S3.Bucket, cr.Status.Storage.S3.Bucket)
+	}
+
+	for _, body := range rt.reqBodies {
+		if !strings.Contains(string(body), "LifecycleConfiguration") {
+			continue
+		}
+
+		lifecycle := s3.BucketLifecycleConfiguration{}
+		xmldec := xml.NewDecoder(bytes.NewBuffer(body))
+		if err := xmlutil.UnmarshalXML(&lifecycle, xmldec, ""); err != nil {
+			t.Fatalf("error decoding lifecycle request: %s", err)
+		}
+		if len(lifecycle.Rules) != 1 {
+			t.Fatalf("expected exactly one lifecycle rule, got %d", len(lifecycle.Rules))
+		}
+
+		rule := lifecycle.Rules[0]
+		if rule.AbortIncompleteMultipartUpload == nil {
+			t.Fatal("expected the lifecycle rule to abort incomplete multipart uploads")
+		}
+		if days := aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation); days != 1 {
+			t.Errorf("expected incomplete uploads to be aborted after 1 day, got %d", days)
+		}
+		return
+	}
+	t.Fatal("no request for bucket lifecycle configuration found")
+}
+
+func TestVerifyPermissions(t *testing.T) {
+	ignoreCABundle(t)
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS: &configv1.AWSPlatformStatus{
+					Region: "us-west-1",
+				},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"aws_access_key_id":     []byte("access_key_id"),
+			"aws_secret_access_key": []byte("secret_access_key"),
+		},
+	})
+	listers := builder.BuildListers()
+
+	for _, tt := range []struct {
+		name           string
+		responseCodes  []int
+		expectedAction string
+	}{
+		{
+			name: "all permissions granted",
+		},
+		{
+			name:           "cannot write objects",
+			responseCodes:  []int{http.StatusForbidden},
+			expectedAction: "s3:PutObject",
+		},
+		{
+			name:           "cannot delete objects",
+			responseCodes:  []int{http.StatusOK, http.StatusForbidden},
+			expectedAction: "s3:DeleteObject",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := NewDriver(context.Background(), &imageregistryv1.ImageRegistryConfigStorageS3{
+				Bucket: "a-bucket",
+			}, &listers.StorageListers)
+
+			rt := &tripper{}
+			for _, code := range tt.responseCodes {
+				rt.AddResponse(code)
+			}
+			drv.roundTripper = rt
+
+			err := drv.VerifyPermissions()
+			if tt.expectedAction == "" {
+				if err != nil {
+					t.Fatalf("unexpected err %q", err)
+				}
+				return
+			}
+
+			var permErr *util.InsufficientPermissionsError
+			if !errors.As(err, &permErr) {
+				t.Fatalf("expected InsufficientPermissionsError, got %v", err)
+			}
+			if permErr.Action != tt.expectedAction {
+				t.Errorf("expected missing action %s, got %s", tt.expectedAction, permErr.Action)
+			}
+		})
+	}
+}
+
+// permissionsTripper denies writes of the permissions check object and
+// accepts all other requests.
+type permissionsTripper struct {
+	denyWrites   bool
+	checkObjects []string
+}
+
+func (r *permissionsTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	code := http.StatusOK
+	if strings.HasSuffix(req.URL.Path, "/"+util.PermissionsCheckObjectName) {
+		r.checkObjects = append(r.checkObjects, req.URL.Path)
+		if r.denyWrites && req.Method == http.MethodPut {
+			code = http.StatusForbidden
+		}
+	}
+	return &http.Response{
+		StatusCode: code,
+		Body:       io.NopCloser(bytes.NewBufferString("{}")),
+	}, nil
+}
+
+func TestCreateStorageVerifiesPermissions(t *testing.T) {
+	ignoreCABundle(t)
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "tinfra",
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS: &configv1.AWSPlatformStatus{
+					Region: "us-west-1",
+				},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"aws_access_key_id":     []byte("access_key_id"),
+			"aws_secret_access_key": []byte("secret_access_key"),
+		},
+	})
+	listers := builder.BuildListers()
+
+	newConfig := func(managementState string) *imageregistryv1.Config {
+		return &imageregistryv1.Config{
+			Spec: imageregistryv1.ImageRegistrySpec{
+				Storage: imageregistryv1.ImageRegistryConfigStorage{
+					ManagementState: managementState,
+					S3: &imageregistryv1.ImageRegistryConfigStorageS3{
+						Bucket: "a-bucket",
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("unmanaged bucket", func(t *testing.T) {
+		cr := newConfig(imageregistryv1.StorageManagementStateUnmanaged)
+		drv := NewDriver(context.Background(), cr.Spec.Storage.S3, &listers.StorageListers)
+		rt := &permissionsTripper{denyWrites: true}
+		drv.roundTripper = rt
+
+		err := drv.CreateStorage(cr)
+		var permErr *util.InsufficientPermissionsError
+		if !errors.As(err, &permErr) {
+			t.Fatalf("expected InsufficientPermissionsError, got %v", err)
+		}
+		if len(rt.checkObjects) == 0 {
+			t.Error("expected the permissions to be verified")
+		}
+		if cond := util.FetchCondition(cr, defaults.StorageExists); cond.Reason != util.InsufficientPermissionsReason {
+			t.Errorf("expected StorageExists reason %s, got %#+v", util.InsufficientPermissionsReason, cond)
+		}
+	})
+
+	t.Run("managed bucket", func(t *testing.T) {
+		cr := newConfig(imageregistryv1.StorageManagementStateManaged)
+		drv := NewDriver(context.Background(), cr.Spec.Storage.S3, &listers.StorageListers)
+		rt := &permissionsTripper{denyWrites: true}
+		drv.roundTripper = rt
+
+		err := drv.CreateStorage(cr)
+		var permErr *util.InsufficientPermissionsError
+		if !errors.As(err, &permErr) {
+			t.Fatalf("expected InsufficientPermissionsError, got %v", err)
+		}
+		for _, path := range rt.checkObjects {
+			if !strings.HasSuffix(path, "/docker/registry/v2/openshift-image-registry-permissions-check") {
+				t.Errorf("expected the check object under the registry prefix, got %s", path)
+			}
+		}
+		if cond := util.FetchCondition(cr, defaults.StorageExists); cond.Reason != util.InsufficientPermissionsReason {
+			t.Errorf("expected StorageExists reason %s, got %#+v", util.InsufficientPermissionsReason, cond)
+		}
+
+		// The bucket is set up again until the check passes.
+		if exists, err := drv.StorageExists(cr); err != nil || exists {
+			t.Fatalf("expected the storage to be reported as missing, got %t, %v", exists, err)
+		}
+
+		rt.denyWrites = false
+		rt.checkObjects = nil
+		if err := drv.CreateStorage(cr); err != nil {
+			t.Fatalf("unexpected err %q", err)
+		}
+		if len(rt.checkObjects) == 0 {
+			t.Error("expected the permissions to be verified")
+		}
+		if exists, err := drv.StorageExists(cr); err != nil || !exists {
+			t.Fatalf("expected the storage to exist, got %t, %v", exists, err)
+		}
+
+		// Later checks do not write into the bucket.
+		rt.checkObjects = nil
+		if _, err := drv.StorageExists(cr); err != nil {
+			t.Fatalf("unexpected err %q", err)
+		}
+		if len(rt.checkObjects) != 0 {
+			t.Errorf("expected StorageExists not to write into the bucket, got requests for %v", rt.checkObjects)
+		}
+	})
+}
+
+func TestCreateStorageUnknownError(t *testing.T) {
+	ignoreCABundle(t)
+
+	builder := cirofake.NewFixturesBuilder()
+	builder.AddInfraConfig(&configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "tinfra",
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS: &configv1.AWSPlatformStatus{
+					Region: "us-west-1",
+				},
+			},
+		},
+	})
+	builder.AddSecrets(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.CloudCredentialsName,
+			Namespace: defaults.ImageRegistryOperatorNamespace,
+		},
+		Data: map[string][]byte{
+			"aws_access_key_id":     []byte("access_key_id"),
+			"aws_secret_access_key": []byte("secret_access_key"),
+		},
+	})
+	listers := builder.BuildListers()
+
+	cr := &imageregistryv1.Config{
+		Spec: imageregistryv1.ImageRegistrySpec{
+			Storage: imageregistryv1.ImageRegistryConfigStorage{
+				S3: &imageregistryv1.ImageRegistryConfigStorageS3{},
+			},
+		},
+	}
+
+	drv := NewDriver(context.Background(), cr.Spec.Storage.S3, &listers.StorageListers)
+	drv.roundTripper = &tripper{}
+	createErr := errors.New("unable to create the bucket")
+	drv.customizeHandlers = func(handlers *request.Handlers) {
+		handlers.Validate.PushBack(func(r *request.Request) {
+			if r.Operation.Name == "CreateBucket" {
+				r.Error = createErr
+			}
+		})
+	}
+
+	err := drv.CreateStorage(cr)
+	if !errors.Is(err, createErr) {
+		t.Fatalf("expected the CreateBucket error, got %v", err)
+	}
+
+	cond := util.FetchCondition(cr, defaults.StorageExists)
+	if cond.Status != operatorapi.ConditionUnknown || cond.Reason != "Unknown Error Occurred" {
+		t.Errorf("expected StorageExists to be Unknown, got %#+v", cond)
+	}
+	if cr.Status.Storage.S3 != nil {
+		t.Errorf("expected the bucket not to be recorded in status, got %#+v", cr.Status.Storage.S3)
+	}
+}
@@ -88,6 +88,24 @@ type Driver interface {
 	ID() string
 }
 
+// PermissionsVerifier is implemented by drivers that can check that their
+// credentials allow all operations needed by the image registry.
+type PermissionsVerifier interface {
+	// VerifyPermissions returns *util.InsufficientPermissionsError if the
+	// credentials do not allow an operation on the storage backend, or
+	// *util.QuotaExceededError if the backend rejects writes because of its
+	// quota.
+	VerifyPermissions() error
+}
+
+// QuotaChecker is implemented by drivers that can tell whether the storage
+// quota is exhausted without writing into the storage.
+type QuotaChecker interface {
+	// CheckQuota returns *util.QuotaExceededError if the storage backend
+	// does not accept new data because of its quota.
+	CheckQuota() error
+}
+
 func NewDriver(cfg *imageregistryv1.ImageRegistryConfigStorage, kubeconfig *rest.Config, listers *regopclient.StorageListers) (Driver, error) {
 	var names []string
 	var drivers []Driver
@@ -121,7 +139,7 @@ func NewDriver(cfg *imageregistryv1.ImageRegistryConfigStorage, kubeconfig *rest
 	}
 
 	if cfg.PVC != nil {
-		drv, err := pvc.NewDriver(cfg.PVC, kubeconfig)
+		drv, err := pvc.NewDriver(cfg.PVC, kubeconfig, listers.StorageClasses)
 		if err != nil {
 			return nil, err
 		}
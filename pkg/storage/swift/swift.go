@@ -33,6 +33,12 @@ import (
 	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 )
 
+const (
+	// storageExistsReasonAuthenticationFailed is the StorageExists reason
+	// when Keystone rejects the registry credentials.
+	storageExistsReasonAuthenticationFailed = "AuthenticationFailed"
+)
+
 type Swift struct {
 	AuthURL                     string
 	Username                    string
@@ -144,7 +150,7 @@ func GetConfig(listers *regopclient.StorageListers) (*Swift, error) {
 				cfg.RegionName = cloud.RegionName
 				cfg.IdentityAPIVersion = cloud.IdentityAPIVersion
 			} else {
-				return nil, fmt.Errorf("clouds.yaml does not contain required cloud \"openstack\"")
+				return nil, fmt.Errorf("clouds.yaml does not contain required cloud %q", cloudName)
 			}
 		} else {
 			return nil, fmt.Errorf("secret %q does not contain required key \"clouds.yaml\"", fmt.Sprintf("%s/%s", defaults.ImageRegistryOperatorNamespace, defaults.CloudCredentialsName))
@@ -160,10 +166,12 @@ func GetConfig(listers *regopclient.StorageListers) (*Swift, error) {
 		userPassValid := len(cfg.Username) > 0 && len(cfg.Password) > 0
 		appCredsValid := len(cfg.ApplicationCredentialID) > 0 && len(cfg.ApplicationCredentialName) > 0 && len(cfg.ApplicationCredentialSecret) > 0
 		if !userPassValid && !appCredsValid {
-			return nil, fmt.Errorf(
-				"secret %q does not contain required keys 'REGISTRY_STORAGE_SWIFT_USERNAME' and 'REGISTRY_STORAGE_SWIFT_PASSWORD'; or 'REGISTRY_STORAGE_SWIFT_APPLICATIONCREDENTIALID', 'REGISTRY_STORAGE_SWIFT_APPLICATIONCREDENTIALNAME' and 'REGISTRY_STORAGE_SWIFT_APPLICATIONCREDENTIALSECRET'",
-				fmt.Sprintf("%s/%s", sec.Namespace, sec.Name),
-			)
+			return nil, &util.InvalidConfigurationError{
+				Err: fmt.Errorf(
+					"secret %q does not contain required keys 'REGISTRY_STORAGE_SWIFT_USERNAME' and 'REGISTRY_STORAGE_SWIFT_PASSWORD'; or 'REGISTRY_STORAGE_SWIFT_APPLICATIONCREDENTIALID', 'REGISTRY_STORAGE_SWIFT_APPLICATIONCREDENTIALNAME' and 'REGISTRY_STORAGE_SWIFT_APPLICATIONCREDENTIALSECRET'",
+					fmt.Sprintf("%s/%s", sec.Namespace, sec.Name),
+				),
+			}
 		}
 	}
 
@@ -392,27 +400,140 @@ func (d *driver) containerExists(client *gophercloud.ServiceClient, containerNam
 	return err
 }
 
+// isNotFound returns true if err means that the requested Swift resource
+// does not exist.
+func isNotFound(err error) bool {
+	var notFound gophercloud.ErrDefault404
+	var resourceNotFound *gophercloud.ErrResourceNotFound
+	return errors.As(err, &notFound) || errors.As(err, &resourceNotFound)
+}
+
+// isUnauthorized returns true if err means that Keystone rejected the
+// credentials.
+func isUnauthorized(err error) bool {
+	var unauthorized gophercloud.ErrDefault401
+	return errors.As(err, &unauthorized)
+}
+
+// isForbidden returns true if err means that the credentials do not allow
+// the request.
+func isForbidden(err error) bool {
+	var forbidden gophercloud.ErrDefault403
+	return errors.As(err, &forbidden)
+}
+
+// isQuotaExceeded returns true if err means that Swift rejected an upload
+// because the container or account quota is exhausted.
+func isQuotaExceeded(err error) bool {
+	var respErr gophercloud.ErrUnexpectedResponseCode
+	return errors.As(err, &respErr) && respErr.Actual == http.StatusRequestEntityTooLarge
+}
+
 func (d *driver) StorageExists(cr *imageregistryv1.Config) (bool, error) {
 	client, err := d.getSwiftClient()
 	if err != nil {
+		if isUnauthorized(err) {
+			util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, storageExistsReasonAuthenticationFailed, err.Error())
+			return false, err
+		}
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionUnknown, "Could not connect to registry storage", err.Error())
 		return false, err
 	}
 
 	err = d.containerExists(client, cr.Spec.Storage.Swift.Container)
 	if err != nil {
-		if serr, ok := err.(*gophercloud.ErrResourceNotFound); ok {
-			util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "Storage does not exist", serr.Error())
+		if isNotFound(err) {
+			util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, "Storage does not exist", err.Error())
 			return false, nil
 		}
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionUnknown, "Unknown error occurred", err.Error())
 		return false, err
 	}
 
+	// The container has to be set up again until the permissions check
+	// passes.
+	if util.PermissionsCheckFailed(cr) {
+		return false, nil
+	}
+
 	util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionTrue, "Swift container Exists", "")
 	return true, nil
 }
 
+// VerifyPermissions checks that the credentials allow the image registry to
+// write and delete objects in the container and that the container quota is
+// not exhausted. It uploads an empty object under the prefix used by the image
+// registry and removes it.
+func (d *driver) VerifyPermissions() error {
+	client, err := d.getSwiftClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = objects.Create(client, d.Config.Container, util.PermissionsCheckObjectName, objects.CreateOpts{
+		Content: strings.NewReader(""),
+	}).Extract()
+	if isForbidden(err) {
+		return &util.InsufficientPermissionsError{Action: "write access to the container", Err: err}
+	} else if isQuotaExceeded(err) {
+		return &util.QuotaExceededError{Err: err}
+	} else if err != nil {
+		return err
+	}
+
+	_, err = objects.Delete(client, d.Config.Container, util.PermissionsCheckObjectName, objects.DeleteOpts{}).Extract()
+	if isForbidden(err) {
+		return &util.InsufficientPermissionsError{Action: "delete access to the container", Err: err}
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CheckQuota checks that the container quota is not exhausted. It compares
+// the usage of the container with the quota from its metadata, so unlike
+// VerifyPermissions it does not write into the container.
+func (d *driver) CheckQuota() error {
+	client, err := d.getSwiftClient()
+	if err != nil {
+		return err
+	}
+
+	res := containers.Get(client, d.Config.Container, containers.GetOpts{})
+	header, err := res.Extract()
+	if err != nil {
+		return err
+	}
+	metadata, err := res.ExtractMetadata()
+	if err != nil {
+		return err
+	}
+
+	for _, quota := range []struct {
+		key  string
+		used int64
+		unit string
+	}{
+		{key: "Quota-Bytes", used: header.BytesUsed, unit: "bytes"},
+		{key: "Quota-Count", used: header.ObjectCount, unit: "objects"},
+	} {
+		value, ok := metadata[quota.key]
+		if !ok {
+			continue
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("unable to parse the %s metadata of the container %s: %v", quota.key, d.Config.Container, err)
+		}
+		if quota.used >= limit {
+			return &util.QuotaExceededError{
+				Err: fmt.Errorf("the container %s holds %d %s, its quota is %d", d.Config.Container, quota.used, quota.unit, limit),
+			}
+		}
+	}
+	return nil
+}
+
 func (d *driver) StorageChanged(cr *imageregistryv1.Config) bool {
 	if !reflect.DeepEqual(cr.Status.Storage.Swift, cr.Spec.Storage.Swift) {
 		util.UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionUnknown, "Swift Configuration Changed", "Swift storage is in an unknown state")
@@ -500,6 +621,13 @@ func (d *driver) CreateStorage(cr *imageregistryv1.Config) error {
 		break
 	}
 
+	// Check once that the registry will be able to push into the container.
+	// The check object is kept under the prefix used by the registry, so
+	// containers that are not managed by the operator are checked as well.
+	if err := util.VerifyPermissions(cr, d.VerifyPermissions); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -25,6 +25,8 @@ import (
 	configlisters "github.com/openshift/client-go/config/listers/config/v1"
 
 	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
+	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage/util"
 )
 
 const (
@@ -339,31 +341,33 @@ func TestStorageManagementState(t *testing.T) {
 			name:                    "container provided (exists)",
 			container:               "a-container",
 			expectedManagementState: imageregistryv1.StorageManagementStateUnmanaged,
-			headers:                 []int{http.StatusOK},
+			headers:                 []int{http.StatusOK, http.StatusCreated, http.StatusNoContent},
 		},
 		{
 			name:                    "container provided (does not exist)",
 			container:               "another-container",
 			expectedManagementState: imageregistryv1.StorageManagementStateManaged,
-			headers:                 []int{http.StatusNotFound, http.StatusNoContent},
+			headers:                 []int{http.StatusNotFound, http.StatusNoContent, http.StatusCreated, http.StatusNoContent},
 		},
 		{
 			name:                    "container provided with management set (exists)",
 			container:               "yet-another-container",
 			managementState:         "foo",
 			expectedManagementState: "foo",
-			headers:                 []int{http.StatusOK},
+			headers:                 []int{http.StatusOK, http.StatusCreated, http.StatusNoContent},
 		},
 		{
 			name:                    "container provided with management set (does not exist)",
 			container:               "container-strikes-back",
 			expectedManagementState: "bar",
 			managementState:         "bar",
-			headers:                 []int{http.StatusNotFound, http.StatusNoContent},
+			headers:                 []int{http.StatusNotFound, http.StatusNoContent, http.StatusCreated, http.StatusNoContent},
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			httpHandler.setResponses([]int{http.StatusNotFound, http.StatusNoContent})
+			// The container requests are followed by the permissions
+			// check, which uploads and removes an object.
+			httpHandler.setResponses([]int{http.StatusNotFound, http.StatusNoContent, http.StatusCreated, http.StatusNoContent})
 			if tt.headers != nil {
 				httpHandler.setResponses(tt.headers)
 			}
@@ -1176,7 +1180,10 @@ func TestConfigStatusUpdate(t *testing.T) {
 
 	httpHandler := &handler{}
 	th.Mux.HandleFunc("/", httpHandler.request)
-	httpHandler.setResponses([]int{http.StatusOK, http.StatusOK})
+	httpHandler.setResponses([]int{
+		http.StatusOK, http.StatusCreated, http.StatusNoContent,
+		http.StatusOK, http.StatusCreated, http.StatusNoContent,
+	})
 
 	drv, installConfig := mockConfig(
 		false, th.Endpoint()+"v3", MockUPISecretNamespaceLister{}, false,
@@ -1214,3 +1221,338 @@ func TestConfigStatusUpdate(t *testing.T) {
 		spew.Dump(status)
 	}
 }
+
+func TestSwiftCABundle(t *testing.T) {
+	defer func(orig map[string]string) {
+		fakeCloudProviderConfigMap = orig
+	}(fakeCloudProviderConfigMap)
+
+	const caBundle = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+	for _, tt := range []struct {
+		name           string
+		configMapData  map[string]string
+		expectedBundle string
+		expectedSystem bool
+	}{
+		{
+			name:           "no ca bundle in the cloud provider config",
+			configMapData:  map[string]string{"config": "[Global]"},
+			expectedSystem: true,
+		},
+		{
+			name: "ca bundle in the cloud provider config",
+			configMapData: map[string]string{
+				"config":        "[Global]",
+				"ca-bundle.pem": caBundle,
+			},
+			expectedBundle: caBundle,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeCloudProviderConfigMap = tt.configMapData
+
+			d := NewDriver(&imageregistryv1.ImageRegistryConfigStorageSwift{}, &regopclient.StorageListers{
+				OpenShiftConfig: MockConfigMapNamespaceLister{},
+			})
+
+			bundle, system, err := d.CABundle()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bundle != tt.expectedBundle {
+				t.Errorf("expected ca bundle %q, got %q", tt.expectedBundle, bundle)
+			}
+			if system != tt.expectedSystem {
+				t.Errorf("expected system to be %t, got %t", tt.expectedSystem, system)
+			}
+		})
+	}
+}
+
+func TestSwiftStorageExistsAuthenticationFailed(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "POST")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	d, installConfig := mockConfig(false, th.Endpoint()+"v3", MockUPISecretNamespaceLister{}, false)
+
+	res, err := d.StorageExists(&installConfig)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	th.AssertEquals(t, false, res)
+
+	cond := util.FetchCondition(&installConfig, defaults.StorageExists)
+	th.AssertEquals(t, operatorapi.ConditionFalse, cond.Status)
+	th.AssertEquals(t, "AuthenticationFailed", cond.Reason)
+}
+
+func TestSwiftStorageExistsContainerMissing(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	handleAuthentication(t, "container")
+
+	th.Mux.HandleFunc("/"+container, func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "HEAD")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	d, installConfig := mockConfig(false, th.Endpoint()+"v3", MockUPISecretNamespaceLister{}, false)
+
+	res, err := d.StorageExists(&installConfig)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, false, res)
+
+	cond := util.FetchCondition(&installConfig, defaults.StorageExists)
+	th.AssertEquals(t, operatorapi.ConditionFalse, cond.Status)
+	th.AssertEquals(t, "Storage does not exist", cond.Reason)
+}
+
+func TestSwiftVerifyPermissions(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		createStatus   int
+		deleteStatus   int
+		expectPermErr  bool
+		expectQuotaErr bool
+	}{
+		{
+			name:         "credentials allow to modify the container",
+			createStatus: http.StatusCreated,
+			deleteStatus: http.StatusNoContent,
+		},
+		{
+			name:          "writes are forbidden",
+			createStatus:  http.StatusForbidden,
+			expectPermErr: true,
+		},
+		{
+			name:          "deletes are forbidden",
+			createStatus:  http.StatusCreated,
+			deleteStatus:  http.StatusForbidden,
+			expectPermErr: true,
+		},
+		{
+			name:           "quota is exceeded",
+			createStatus:   http.StatusRequestEntityTooLarge,
+			expectQuotaErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			handleAuthentication(t, "container")
+
+			deleted := false
+			th.Mux.HandleFunc("/"+container+"/"+util.PermissionsCheckObjectName, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodPut:
+					w.WriteHeader(tt.createStatus)
+				case http.MethodDelete:
+					deleted = true
+					w.WriteHeader(tt.deleteStatus)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+				}
+			})
+
+			d, _ := mockConfig(false, th.Endpoint()+"v3", MockUPISecretNamespaceLister{}, false)
+
+			err := d.VerifyPermissions()
+
+			var permErr *util.InsufficientPermissionsError
+			if got := errors.As(err, &permErr); got != tt.expectPermErr {
+				t.Errorf("expected insufficient permissions error to be %t, got %v", tt.expectPermErr, err)
+			}
+			var quotaErr *util.QuotaExceededError
+			if got := errors.As(err, &quotaErr); got != tt.expectQuotaErr {
+				t.Errorf("expected quota exceeded error to be %t, got %v", tt.expectQuotaErr, err)
+			}
+			if !tt.expectPermErr && !tt.expectQuotaErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.createStatus == http.StatusCreated && !deleted {
+				t.Errorf("expected the probe object to be removed")
+			}
+		})
+	}
+}
+
+func TestSwiftCheckQuota(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		headers        map[string]string
+		expectQuotaErr bool
+	}{
+		{
+			name: "container has no quota",
+		},
+		{
+			name: "container is below its quota",
+			headers: map[string]string{
+				"X-Container-Meta-Quota-Bytes": "1000",
+				"X-Container-Meta-Quota-Count": "10",
+			},
+		},
+		{
+			name: "bytes quota is exhausted",
+			headers: map[string]string{
+				"X-Container-Meta-Quota-Bytes": "100",
+			},
+			expectQuotaErr: true,
+		},
+		{
+			name: "objects quota is exhausted",
+			headers: map[string]string{
+				"X-Container-Meta-Quota-Count": "4",
+			},
+			expectQuotaErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			handleAuthentication(t, "container")
+
+			th.Mux.HandleFunc("/"+container, func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, "HEAD")
+				w.Header().Set("X-Container-Bytes-Used", "100")
+				w.Header().Set("X-Container-Object-Count", "4")
+				for k, v := range tt.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			d, _ := mockConfig(false, th.Endpoint()+"v3", MockUPISecretNamespaceLister{}, false)
+
+			err := d.CheckQuota()
+
+			var quotaErr *util.QuotaExceededError
+			if got := errors.As(err, &quotaErr); got != tt.expectQuotaErr {
+				t.Errorf("expected quota exceeded error to be %t, got %v", tt.expectQuotaErr, err)
+			}
+			if !tt.expectQuotaErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSwiftCreateStorageVerifiesPermissions(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	handleAuthentication(t, "container")
+
+	created := false
+	th.Mux.HandleFunc("/"+container, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut:
+			created = true
+			w.WriteHeader(http.StatusCreated)
+		case created:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	th.Mux.HandleFunc("/"+container+"/"+util.PermissionsCheckObjectName, func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "PUT")
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	d, installConfig := mockConfig(false, th.Endpoint()+"v3", MockUPISecretNamespaceLister{}, false)
+
+	err := d.CreateStorage(&installConfig)
+	var permErr *util.InsufficientPermissionsError
+	if !errors.As(err, &permErr) {
+		t.Fatalf("expected insufficient permissions error, got %v", err)
+	}
+	th.AssertEquals(t, imageregistryv1.StorageManagementStateManaged, installConfig.Spec.Storage.ManagementState)
+
+	cond := util.FetchCondition(&installConfig, defaults.StorageExists)
+	th.AssertEquals(t, operatorapi.ConditionFalse, cond.Status)
+	th.AssertEquals(t, util.InsufficientPermissionsReason, cond.Reason)
+
+	// The container is set up again until the check passes.
+	res, err := d.StorageExists(&installConfig)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, false, res)
+}
+
+func TestGetConfigCloudName(t *testing.T) {
+	fakeCloudsYAML = map[string][]byte{
+		cloudSecretKey: []byte(`clouds:
+  openstack:
+    auth:
+      auth_url: https://default.example.com/v3
+      username: default-user
+      password: default-password
+  mycloud:
+    auth:
+      auth_url: https://mycloud.example.com/v3
+      project_name: ` + tenant + `
+      username: ` + username + `
+      password: ` + password + `
+      user_domain_name: ` + domain + `
+    region_name: RegionTwo`),
+	}
+
+	for _, tt := range []struct {
+		name        string
+		cloudName   string
+		expected    *Swift
+		expectedErr string
+	}{
+		{
+			name:      "default cloud",
+			cloudName: "",
+			expected: &Swift{
+				AuthURL:  "https://default.example.com/v3",
+				Username: "default-user",
+				Password: "default-password",
+			},
+		},
+		{
+			name:      "cloud from the infrastructure status",
+			cloudName: "mycloud",
+			expected: &Swift{
+				AuthURL:    "https://mycloud.example.com/v3",
+				Username:   username,
+				Password:   password,
+				Tenant:     tenant,
+				Domain:     domain,
+				RegionName: "RegionTwo",
+			},
+		},
+		{
+			name:        "unknown cloud",
+			cloudName:   "othercloud",
+			expectedErr: `clouds.yaml does not contain required cloud "othercloud"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := GetConfig(&regopclient.StorageListers{
+				Secrets:         MockIPISecretNamespaceLister{},
+				Infrastructures: fakeInfrastructureLister(tt.cloudName),
+			})
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Fatalf("expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg, tt.expected) {
+				t.Errorf("expected config %#+v, got %#+v", tt.expected, cfg)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"regexp"
@@ -8,11 +9,13 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metaapi "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 
 	configv1 "github.com/openshift/api/config/v1"
 	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
 	operatorapi "github.com/openshift/api/operator/v1"
 	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 
 	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
@@ -59,6 +62,98 @@ func UpdateCondition(cr *imageregistryv1.Config, conditionType string, status op
 	cr.Status.Conditions = conditions
 }
 
+// InsufficientPermissionsError is returned when the storage credentials do
+// not allow an action that is needed by the image registry.
+type InsufficientPermissionsError struct {
+	Action string
+	Err    error
+}
+
+func (e *InsufficientPermissionsError) Error() string {
+	return fmt.Sprintf("missing %s: %s", e.Action, e.Err)
+}
+
+func (e *InsufficientPermissionsError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidConfigurationError is returned when the storage cannot be used with
+// the current image registry configuration. Retrying does not help until the
+// configuration is changed.
+type InvalidConfigurationError struct {
+	Err error
+}
+
+func (e *InvalidConfigurationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidConfigurationError) Unwrap() error {
+	return e.Err
+}
+
+// QuotaExceededError is returned when the storage backend rejects writes
+// because the storage quota is exhausted.
+type QuotaExceededError struct {
+	Err error
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %s", e.Err)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return e.Err
+}
+
+// PermissionsCheckObjectName is the object that drivers write and remove to
+// check that the credentials allow the image registry to push. It is placed
+// under the prefix where the image registry keeps its data, so that policies
+// which are scoped to that prefix allow the check.
+const PermissionsCheckObjectName = "docker/registry/v2/openshift-image-registry-permissions-check"
+
+// Reasons of the StorageExists condition when the permissions check fails.
+const (
+	InsufficientPermissionsReason = "InsufficientPermissions"
+	StorageQuotaExceededReason    = "StorageQuotaExceeded"
+)
+
+// VerifyPermissions runs verify once the storage is set up and records
+// missing permissions or an exhausted quota in the StorageExists condition.
+// Other errors are logged and ignored, the check is not needed for the
+// storage to work and some storage backends do not support it fully.
+func VerifyPermissions(cr *imageregistryv1.Config, verify func() error) error {
+	err := verify()
+
+	var permErr *InsufficientPermissionsError
+	var quotaErr *QuotaExceededError
+	switch {
+	case errors.As(err, &permErr):
+		UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, InsufficientPermissionsReason, err.Error())
+		return err
+	case errors.As(err, &quotaErr):
+		UpdateCondition(cr, defaults.StorageExists, operatorapi.ConditionFalse, StorageQuotaExceededReason, err.Error())
+		return err
+	case err != nil:
+		klog.Warningf("unable to verify the storage permissions: %s", err)
+	}
+	return nil
+}
+
+// PermissionsCheckFailed returns true if the last check made by
+// VerifyPermissions failed. The storage should be set up again in this case.
+func PermissionsCheckFailed(cr *imageregistryv1.Config) bool {
+	cond := v1helpers.FindOperatorCondition(cr.Status.Conditions, defaults.StorageExists)
+	if cond == nil {
+		return false
+	}
+	switch cond.Reason {
+	case InsufficientPermissionsReason, StorageQuotaExceededReason:
+		return true
+	}
+	return false
+}
+
 // FetchCondition will return the provided condition.
 func FetchCondition(cr *imageregistryv1.Config, conditionType string) (c operatorapi.OperatorCondition) {
 	for _, c = range cr.Status.Conditions {
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 	"testing"
@@ -9,6 +10,8 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 
 	configv1 "github.com/openshift/api/config/v1"
+	imageregistryv1 "github.com/openshift/api/imageregistry/v1"
+	operatorapi "github.com/openshift/api/operator/v1"
 
 	regopclient "github.com/openshift/cluster-image-registry-operator/pkg/client"
 	"github.com/openshift/cluster-image-registry-operator/pkg/defaults"
@@ -126,3 +129,51 @@ func TestGenerateStorageName(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyPermissions(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		err       error
+		expectErr bool
+		reason    string
+	}{
+		{
+			name: "permissions are sufficient",
+		},
+		{
+			name:      "permissions are missing",
+			err:       &InsufficientPermissionsError{Action: "s3:PutObject", Err: fmt.Errorf("access denied")},
+			expectErr: true,
+			reason:    InsufficientPermissionsReason,
+		},
+		{
+			name:      "quota is exhausted",
+			err:       &QuotaExceededError{Err: fmt.Errorf("quota exceeded")},
+			expectErr: true,
+			reason:    StorageQuotaExceededReason,
+		},
+		{
+			name: "check is not supported",
+			err:  fmt.Errorf("not implemented"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &imageregistryv1.Config{}
+			// Other conditions don't affect the check.
+			UpdateCondition(cr, defaults.StorageEncrypted, operatorapi.ConditionFalse, InsufficientPermissionsReason, "")
+
+			err := VerifyPermissions(cr, func() error { return tt.err })
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expected error to be %t, got %v", tt.expectErr, err)
+			}
+			if failed := PermissionsCheckFailed(cr); failed != tt.expectErr {
+				t.Errorf("expected PermissionsCheckFailed to be %t, got %t", tt.expectErr, failed)
+			}
+			if tt.reason != "" {
+				if cond := FetchCondition(cr, defaults.StorageExists); cond.Status != operatorapi.ConditionFalse || cond.Reason != tt.reason {
+					t.Errorf("expected StorageExists to be False with reason %s, got %#+v", tt.reason, cond)
+				}
+			}
+		})
+	}
+}